@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+)
+
+// Command line tool working with cluster directly through postgres
+
+var (
+	apiKeyCommand = kingpin.Command("apikey", "Manage api keys of external api")
+
+	apiKeyCreateCommand   = apiKeyCommand.Command("create", "Create api key, key is printed only once")
+	apiKeyCreateName      = apiKeyCreateCommand.Flag("name", "Name of key").Required().String()
+	apiKeyCreateScopes    = apiKeyCreateCommand.Flag("scope", "Scope of key (read, write, delete, admin), can be repeated").Required().Enums(postgres.APIKeyScopes...)
+	apiKeyCreateNamespace = apiKeyCreateCommand.Flag("namespace", "Restrict key to namespace").String()
+
+	apiKeyListCommand = apiKeyCommand.Command("list", "List api keys")
+
+	apiKeyRevokeCommand = apiKeyCommand.Command("revoke", "Revoke api key")
+	apiKeyRevokeID      = apiKeyRevokeCommand.Arg("id", "ID of key").Required().Int64()
+)
+
+func createAPIKey(ctx context.Context, pg *postgres.Postgres) error {
+	namespaceID := int64(0)
+	if *apiKeyCreateNamespace != "" {
+		namespace, err := pg.GetNamespaceByName(ctx, *apiKeyCreateNamespace)
+		if err != nil {
+			return err
+		}
+		if !namespace.IsExist() {
+			return fmt.Errorf("Namespace %v not found", *apiKeyCreateNamespace)
+		}
+		namespaceID = namespace.ID
+	}
+
+	raw, hash, err := postgres.GenerateAPIKey()
+	if err != nil {
+		return err
+	}
+	key, err := pg.CreateAPIKey(ctx, *apiKeyCreateName, hash, *apiKeyCreateScopes, namespaceID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("id: %v\nkey: %v\n", key.ID, raw)
+	return nil
+}
+
+func listAPIKeys(ctx context.Context, pg *postgres.Postgres) error {
+	keys, err := pg.GetAPIKeys(ctx)
+	if err != nil {
+		return err
+	}
+	namespaces, err := pg.GetNamespaces(ctx)
+	if err != nil {
+		return err
+	}
+	names := map[int64]string{}
+	for _, namespace := range namespaces {
+		names[namespace.ID] = namespace.Name
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSCOPES\tNAMESPACE\tCREATED_AT\tREVOKED_AT")
+	for _, key := range keys {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n",
+			key.ID, key.Name, strings.Join(key.Scopes, ","), names[key.NamespaceID], key.Created_at, key.Revoked_at)
+	}
+	return w.Flush()
+}
+
+func revokeAPIKey(ctx context.Context, pg *postgres.Postgres) error {
+	key, err := pg.RevokeAPIKey(ctx, *apiKeyRevokeID)
+	if err != nil {
+		return err
+	}
+	if !key.IsExist() {
+		return fmt.Errorf("Api key %v not found or already revoked", *apiKeyRevokeID)
+	}
+	fmt.Printf("revoked: %v\n", key.ID)
+	return nil
+}
+
+func run(ctx context.Context, command string) error {
+	err := postgres.Init(ctx)
+	if err != nil {
+		return err
+	}
+	defer postgres.Default.Close()
+
+	switch command {
+	case apiKeyCreateCommand.FullCommand():
+		return createAPIKey(ctx, postgres.Default)
+	case apiKeyListCommand.FullCommand():
+		return listAPIKeys(ctx, postgres.Default)
+	case apiKeyRevokeCommand.FullCommand():
+		return revokeAPIKey(ctx, postgres.Default)
+	}
+	return nil
+}
+
+func main() {
+	command := kingpin.Parse()
+	err := run(context.Background(), command)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,170 @@
+package admin
+
+import (
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+)
+
+type apiKeyInfo struct {
+	ID        int64    `json:"id"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	Namespace string   `json:"namespace"`
+	CreatedAt int64    `json:"created_at"`
+	RevokedAt int64    `json:"revoked_at"`
+}
+
+func newAPIKeyInfo(key postgres.APIKey, namespace string) apiKeyInfo {
+	return apiKeyInfo{
+		ID:        key.ID,
+		Name:      key.Name,
+		Scopes:    key.Scopes,
+		Namespace: namespace,
+		CreatedAt: key.Created_at,
+		RevokedAt: key.Revoked_at,
+	}
+}
+
+// Creates api key, key is returned only in this response
+func CreateAPIKey(pg *postgres.Postgres) gin.HandlerFunc {
+	type request struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		Namespace string   `json:"namespace"`
+	}
+	type response struct {
+		Err string `json:"err"`
+		apiKeyInfo
+		Key string `json:"key"`
+	}
+
+	return func(ctx *gin.Context) {
+		req := request{}
+		resp := response{}
+
+		err := ctx.ShouldBindJSON(&req)
+		if err != nil {
+			resp.Err = err.Error()
+			ctx.JSON(400, resp)
+			return
+		}
+		if req.Name == "" {
+			resp.Err = "Invalid name"
+			ctx.JSON(400, resp)
+			return
+		}
+		if len(req.Scopes) == 0 {
+			resp.Err = "Invalid scopes"
+			ctx.JSON(400, resp)
+			return
+		}
+		for _, scope := range req.Scopes {
+			if !slices.Contains(postgres.APIKeyScopes, scope) {
+				resp.Err = "Invalid scope " + scope
+				ctx.JSON(400, resp)
+				return
+			}
+		}
+		namespaceID := int64(0)
+		if req.Namespace != "" {
+			namespace, err := pg.GetNamespaceByName(ctx, req.Namespace)
+			if err != nil {
+				ctx.JSON(500, resp)
+				common.Log.Error(err.Error())
+				return
+			}
+			if !namespace.IsExist() {
+				resp.Err = "Namespace not found"
+				ctx.JSON(404, resp)
+				return
+			}
+			namespaceID = namespace.ID
+		}
+
+		raw, hash, err := postgres.GenerateAPIKey()
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+		key, err := pg.CreateAPIKey(ctx, req.Name, hash, req.Scopes, namespaceID)
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+
+		resp.apiKeyInfo = newAPIKeyInfo(key, req.Namespace)
+		resp.Key = raw
+		ctx.JSON(200, resp)
+	}
+}
+
+func GetAPIKeys(pg *postgres.Postgres) gin.HandlerFunc {
+	type response struct {
+		Err     string       `json:"err"`
+		APIKeys []apiKeyInfo `json:"api_keys"`
+	}
+
+	return func(ctx *gin.Context) {
+		resp := response{APIKeys: []apiKeyInfo{}}
+
+		keys, err := pg.GetAPIKeys(ctx)
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+		namespaces, err := pg.GetNamespaces(ctx)
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+		names := map[int64]string{}
+		for _, namespace := range namespaces {
+			names[namespace.ID] = namespace.Name
+		}
+
+		for _, key := range keys {
+			resp.APIKeys = append(resp.APIKeys, newAPIKeyInfo(key, names[key.NamespaceID]))
+		}
+		ctx.JSON(200, resp)
+	}
+}
+
+func RevokeAPIKey(pg *postgres.Postgres) gin.HandlerFunc {
+	type response struct {
+		Err string `json:"err"`
+		apiKeyInfo
+	}
+
+	return func(ctx *gin.Context) {
+		resp := response{}
+
+		id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+		if err != nil {
+			resp.Err = "Invalid id"
+			ctx.JSON(400, resp)
+			return
+		}
+		key, err := pg.RevokeAPIKey(ctx, id)
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+		if !key.IsExist() {
+			resp.Err = "Api key not found or already revoked"
+			ctx.JSON(404, resp)
+			return
+		}
+
+		resp.apiKeyInfo = newAPIKeyInfo(key, "")
+		ctx.JSON(200, resp)
+	}
+}
@@ -0,0 +1,63 @@
+package admin
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+)
+
+// Returns every node with state of its lock and copies it holds
+func GetCluster(pg *postgres.Postgres) gin.HandlerFunc {
+	type node struct {
+		ID            int64  `json:"id"`
+		Name          string `json:"name"`
+		AdvertiseAddr string `json:"advertise_addr"`
+		State         string `json:"state"`
+		Alive         bool   `json:"alive"`
+		// unix time when lease of node lock expires by clock of postgres, 0 if node never held lock
+		LeaseExpiresAt int64 `json:"lease_expires_at"`
+		Files          int64 `json:"files"`
+		Bytes          int64 `json:"bytes"`
+		Used           int64 `json:"used"`
+		Capacity       int64 `json:"capacity"`
+	}
+	type response struct {
+		Err               string `json:"err"`
+		ReplicationFactor int64  `json:"replication_factor"`
+		Nodes             []node `json:"nodes"`
+	}
+
+	return func(ctx *gin.Context) {
+		resp := response{Nodes: []node{}}
+
+		replicationFactor, err := pg.GetReplicationFactor(ctx)
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+		nodes, err := pg.GetNodesStats(ctx)
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+
+		for _, n := range nodes {
+			resp.Nodes = append(resp.Nodes, node{
+				ID:             n.ID,
+				Name:           n.Name,
+				AdvertiseAddr:  n.AdvertiseAddr,
+				State:          nodeStateNames[n.State],
+				Alive:          n.Alive,
+				LeaseExpiresAt: n.LeaseExpiresAt.Unix(),
+				Files:          n.Files,
+				Bytes:          n.Bytes,
+				Used:           n.Used,
+				Capacity:       n.Capacity,
+			})
+		}
+		resp.ReplicationFactor = replicationFactor
+		ctx.JSON(200, resp)
+	}
+}
@@ -0,0 +1,154 @@
+package admin
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+)
+
+var nodeStateNames = map[int64]string{
+	postgres.NodeStateActive:         "active",
+	postgres.NodeStateDraining:       "draining",
+	postgres.NodeStateDecommissioned: "decommissioned",
+}
+
+type nodeStateResponse struct {
+	Err          string `json:"err"`
+	Name         string `json:"name"`
+	State        string `json:"state"`
+	FilesAtRisk  int64  `json:"files_at_risk"`
+	SafeToRemove bool   `json:"safe_to_remove"`
+}
+
+// Fills resp with state of node, returns false if response was already sent
+func fillNodeState(ctx *gin.Context, pg *postgres.Postgres, node postgres.Node, resp *nodeStateResponse) bool {
+	resp.Name = node.Name
+	resp.State = nodeStateNames[node.State]
+	if node.State == postgres.NodeStateDecommissioned {
+		resp.SafeToRemove = true
+		return true
+	}
+
+	replicationFactor, err := pg.GetReplicationFactor(ctx)
+	if err != nil {
+		ctx.JSON(500, resp)
+		common.Log.Error(err.Error())
+		return false
+	}
+	resp.FilesAtRisk, err = pg.CountFilesAtRisk(ctx, node.ID, replicationFactor)
+	if err != nil {
+		ctx.JSON(500, resp)
+		common.Log.Error(err.Error())
+		return false
+	}
+	resp.SafeToRemove = node.State == postgres.NodeStateDraining && resp.FilesAtRisk == 0
+	return true
+}
+
+// Returns node from path or sends response if it isn't found
+func getNode(ctx *gin.Context, pg *postgres.Postgres, resp *nodeStateResponse) (postgres.Node, bool) {
+	node, err := pg.GetNodeByName(ctx, ctx.Param("name"))
+	if err != nil {
+		ctx.JSON(500, resp)
+		common.Log.Error(err.Error())
+		return node, false
+	}
+	if !node.IsExist() {
+		resp.Err = "Node not found"
+		ctx.JSON(404, resp)
+		return node, false
+	}
+	return node, true
+}
+
+func GetNodeState(pg *postgres.Postgres) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		resp := nodeStateResponse{}
+
+		node, ok := getNode(ctx, pg, &resp)
+		if !ok {
+			return
+		}
+		if !fillNodeState(ctx, pg, node, &resp) {
+			return
+		}
+		ctx.JSON(200, resp)
+	}
+}
+
+// Returns handler which moves node from oldState to state
+func updateNodeState(pg *postgres.Postgres, state int64, oldState int64) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		resp := nodeStateResponse{}
+
+		node, ok := getNode(ctx, pg, &resp)
+		if !ok {
+			return
+		}
+		updatedNode, err := pg.UpdateNodeState(ctx, node.ID, state, oldState)
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+		if !updatedNode.IsExist() {
+			resp.Err = "Node must be " + nodeStateNames[oldState]
+			resp.Name = node.Name
+			resp.State = nodeStateNames[node.State]
+			ctx.JSON(409, resp)
+			return
+		}
+		if !fillNodeState(ctx, pg, updatedNode, &resp) {
+			return
+		}
+		ctx.JSON(200, resp)
+	}
+}
+
+// Starts draining, other nodes take copies of files from draining node
+func DrainNode(pg *postgres.Postgres) gin.HandlerFunc {
+	return updateNodeState(pg, postgres.NodeStateDraining, postgres.NodeStateActive)
+}
+
+// Cancels draining
+func ActivateNode(pg *postgres.Postgres) gin.HandlerFunc {
+	return updateNodeState(pg, postgres.NodeStateActive, postgres.NodeStateDraining)
+}
+
+// Finishes draining if all files of node have enough copies on other nodes
+func DecommissionNode(pg *postgres.Postgres) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		resp := nodeStateResponse{}
+
+		node, ok := getNode(ctx, pg, &resp)
+		if !ok {
+			return
+		}
+		if !fillNodeState(ctx, pg, node, &resp) {
+			return
+		}
+		if !resp.SafeToRemove {
+			resp.Err = "Node isn't drained"
+			ctx.JSON(409, resp)
+			return
+		}
+		if node.State == postgres.NodeStateDecommissioned {
+			ctx.JSON(200, resp)
+			return
+		}
+
+		node, err := pg.DecommissionNode(ctx, node.ID)
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+		if !node.IsExist() {
+			resp.Err = "Node isn't draining"
+			ctx.JSON(409, resp)
+			return
+		}
+		resp.State = nodeStateNames[node.State]
+		ctx.JSON(200, resp)
+	}
+}
@@ -0,0 +1,33 @@
+package admin
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
+	"github.com/muskelo/bronze-pheasant/app/server/rebalance"
+)
+
+// Reports moves which rebalancers would do in the next round
+func GetRebalancePlan(rebalancer *rebalance.Rebalancer) gin.HandlerFunc {
+	type response struct {
+		Err   string           `json:"err"`
+		Moves []rebalance.Move `json:"moves"`
+		Bytes int64            `json:"bytes"`
+	}
+
+	return func(ctx *gin.Context) {
+		resp := response{}
+
+		moves, err := rebalancer.Plan(ctx)
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+
+		resp.Moves = moves
+		for _, move := range moves {
+			resp.Bytes += move.Size
+		}
+		ctx.JSON(200, resp)
+	}
+}
@@ -0,0 +1,61 @@
+package admin
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+)
+
+type replicationFactorResponse struct {
+	Err               string `json:"err"`
+	ReplicationFactor int64  `json:"replication_factor"`
+}
+
+func GetReplicationFactor(pg *postgres.Postgres) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		resp := replicationFactorResponse{}
+
+		replicationFactor, err := pg.GetReplicationFactor(ctx)
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+
+		resp.ReplicationFactor = replicationFactor
+		ctx.JSON(200, resp)
+	}
+}
+
+func SetReplicationFactor(pg *postgres.Postgres) gin.HandlerFunc {
+	type request struct {
+		ReplicationFactor *int64 `json:"replication_factor"`
+	}
+
+	return func(ctx *gin.Context) {
+		req := request{}
+		resp := replicationFactorResponse{}
+
+		err := ctx.ShouldBindJSON(&req)
+		if err != nil {
+			resp.Err = err.Error()
+			ctx.JSON(400, resp)
+			return
+		}
+		if req.ReplicationFactor == nil || *req.ReplicationFactor < 0 {
+			resp.Err = "Invalid replication_factor"
+			ctx.JSON(400, resp)
+			return
+		}
+
+		err = pg.SetReplicationFactor(ctx, *req.ReplicationFactor)
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+
+		resp.ReplicationFactor = *req.ReplicationFactor
+		ctx.JSON(200, resp)
+	}
+}
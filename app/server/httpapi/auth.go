@@ -0,0 +1,120 @@
+package httpapi
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+)
+
+// Returns scope required by request to external api from its method,
+// empty scope means request doesn't need key (cors preflight)
+func methodScope(ctx *gin.Context) string {
+	switch ctx.Request.Method {
+	case "GET", "HEAD":
+		return postgres.APIKeyScopeRead
+	case "POST", "PUT", "PATCH":
+		return postgres.APIKeyScopeWrite
+	case "DELETE":
+		return postgres.APIKeyScopeDelete
+	case "OPTIONS":
+		return ""
+	}
+	return postgres.APIKeyScopeAdmin
+}
+
+func adminScope(ctx *gin.Context) string {
+	return postgres.APIKeyScopeAdmin
+}
+
+// Requests with presigned url to these routes don't need key, handlers verify signature
+var presignedRoutes = map[string]bool{
+	"GET /api/v1/external/files/:uuid":  true,
+	"POST /api/v1/external/files/:uuid": true,
+}
+
+// Returns key from "Authorization: Bearer <key>" or "X-Api-Key: <key>" header
+func requestAPIKey(ctx *gin.Context) string {
+	if key, ok := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(key)
+	}
+	return ctx.GetHeader("X-Api-Key")
+}
+
+// Returns id of namespace which request works with, 0 if request isn't limited to namespace.
+// Namespace is taken from :namespace param, from file of :uuid param or from namespace query.
+// Files addressed only by uuid and resumable uploads don't belong to any namespace.
+func requestNamespaceID(ctx *gin.Context, pg *postgres.Postgres) (int64, error) {
+	name := ctx.Param("namespace")
+	if name == "" && ctx.Param("uuid") == "" {
+		name = ctx.Query("namespace")
+	}
+	if name != "" {
+		namespace, err := pg.GetNamespaceByName(ctx, name)
+		if err != nil || !namespace.IsExist() {
+			return 0, err
+		}
+		return namespace.ID, nil
+	}
+	if uuid := strings.ToLower(ctx.Param("uuid")); uuid != "" && common.IsValidUUID(uuid) {
+		file, err := pg.GetFileByUUID(ctx, uuid)
+		if err != nil || !file.IsExist() {
+			return 0, err
+		}
+		return file.NamespaceID, nil
+	}
+	return 0, nil
+}
+
+// Rejects requests without valid api key having scope returned by scope func.
+// Key restricted to namespace is accepted only for requests to objects and files of that namespace,
+// listing of files requires namespace query.
+func APIKeyAuth(pg *postgres.Postgres, scope func(ctx *gin.Context) string) gin.HandlerFunc {
+	type response struct {
+		Err string `json:"err"`
+	}
+
+	return func(ctx *gin.Context) {
+		required := scope(ctx)
+		if required == "" {
+			return
+		}
+		if common.IsPresigned(ctx) && presignedRoutes[ctx.Request.Method+" "+ctx.FullPath()] {
+			return
+		}
+
+		raw := requestAPIKey(ctx)
+		if raw == "" {
+			ctx.AbortWithStatusJSON(401, response{Err: "Api key required"})
+			return
+		}
+		key, err := pg.GetAPIKeyByHash(ctx, postgres.HashAPIKey(raw))
+		if err != nil {
+			ctx.AbortWithStatusJSON(500, response{})
+			common.Log.Error(err.Error())
+			return
+		}
+		if !key.IsExist() || key.IsRevoked() {
+			ctx.AbortWithStatusJSON(401, response{Err: "Invalid api key"})
+			return
+		}
+		if !key.HasScope(required) {
+			ctx.AbortWithStatusJSON(403, response{Err: "Api key hasn't " + required + " scope"})
+			return
+		}
+
+		if key.NamespaceID != 0 {
+			namespaceID, err := requestNamespaceID(ctx, pg)
+			if err != nil {
+				ctx.AbortWithStatusJSON(500, response{})
+				common.Log.Error(err.Error())
+				return
+			}
+			if namespaceID != key.NamespaceID {
+				ctx.AbortWithStatusJSON(403, response{Err: "Api key is restricted to another namespace"})
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package common
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+)
+
+// Prefix of headers with user tags of file
+const TagHeaderPrefix = "X-Tag-"
+
+const (
+	maxTags           = 64
+	maxTagKeyLength   = 128
+	maxTagValueLength = 1024
+	maxFilenameLength = 1024
+)
+
+// Returns tags from headers with prefix, keys are lower case
+func TagsFromHeaders(header http.Header, prefix string) map[string]string {
+	tags := map[string]string{}
+	for name, values := range header {
+		key, ok := strings.CutPrefix(strings.ToLower(name), strings.ToLower(prefix))
+		if ok && key != "" && len(values) > 0 {
+			tags[key] = values[0]
+		}
+	}
+	return tags
+}
+
+func SetTagHeaders(ctx *gin.Context, tags map[string]string, prefix string) {
+	for key, value := range tags {
+		ctx.Header(prefix+key, value)
+	}
+}
+
+// Checks limits of metadata provided by client
+func CheckMetadata(metadata postgres.FileMetadata) error {
+	if len(metadata.Filename) > maxFilenameLength {
+		return fmt.Errorf("Filename is too long")
+	}
+	if metadata.ContentType != "" {
+		_, _, err := mime.ParseMediaType(metadata.ContentType)
+		if err != nil {
+			return fmt.Errorf("Invalid content type")
+		}
+	}
+	if len(metadata.Tags) > maxTags {
+		return fmt.Errorf("Too many tags")
+	}
+	for key, value := range metadata.Tags {
+		if len(key) > maxTagKeyLength || len(value) > maxTagValueLength {
+			return fmt.Errorf("Tag %v is too long", key)
+		}
+	}
+	return nil
+}
+
+func ContentType(file postgres.File) string {
+	if file.ContentType == "" {
+		return "application/octet-stream"
+	}
+	return file.ContentType
+}
+
+// Returns Content-Disposition with original filename, empty if it's unknown
+func ContentDisposition(file postgres.File) string {
+	if file.Filename == "" {
+		return ""
+	}
+	return mime.FormatMediaType("attachment", map[string]string{"filename": file.Filename})
+}
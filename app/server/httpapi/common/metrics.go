@@ -0,0 +1,31 @@
+package common
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Values of source label, proxied bytes are passed between client and another node
+const (
+	SourceLocal   = "local"
+	SourceProxied = "proxied"
+)
+
+var (
+	UploadedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bronze_pheasant_uploaded_bytes_total",
+		Help: "Bytes of files received from clients, proxied bytes are streamed to peers",
+	}, []string{"source"})
+	DownloadedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bronze_pheasant_downloaded_bytes_total",
+		Help: "Bytes of files sent to clients, proxied bytes are fetched from peers",
+	}, []string{"source"})
+)
+
+// Counts body of response as downloaded bytes
+func countDownloaded(ctx *gin.Context, source string) {
+	if size := ctx.Writer.Size(); size > 0 {
+		DownloadedBytes.WithLabelValues(source).Add(float64(size))
+	}
+}
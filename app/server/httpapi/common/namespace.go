@@ -0,0 +1,18 @@
+package common
+
+import (
+	"regexp"
+	"unicode/utf8"
+)
+
+// Namespaces follow s3 bucket naming rules
+var namespaceRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+func IsValidNamespace(name string) bool {
+	return namespaceRegexp.MatchString(name)
+}
+
+// Keys are non empty utf-8 strings up to 1024 bytes
+func IsValidKey(key string) bool {
+	return key != "" && len(key) <= 1024 && utf8.ValidString(key)
+}
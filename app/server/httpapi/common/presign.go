@@ -0,0 +1,84 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+)
+
+// Presigned url gives access to one file without api key until it expires,
+// it's signed by cluster secret, so any node can verify it
+
+// Query parameters of presigned url
+const (
+	presignExpiresParam   = "expires"
+	presignMaxSizeParam   = "max_size"
+	presignSignatureParam = "signature"
+)
+
+var (
+	ErrInvalidSignature = errors.New("Invalid signature")
+	ErrExpiredSignature = errors.New("Signature expired")
+)
+
+func presignSignature(secret string, method string, uuid string, expires int64, maxSize int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%v\n%v\n%v\n%v", method, uuid, expires, maxSize)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Returns query of presigned url, maxSize 0 means upload isn't limited
+func PresignQuery(secret string, method string, uuid string, expires int64, maxSize int64) url.Values {
+	query := url.Values{}
+	query.Set(presignExpiresParam, strconv.FormatInt(expires, 10))
+	if maxSize > 0 {
+		query.Set(presignMaxSizeParam, strconv.FormatInt(maxSize, 10))
+	}
+	query.Set(presignSignatureParam, presignSignature(secret, method, uuid, expires, maxSize))
+	return query
+}
+
+func IsPresigned(ctx *gin.Context) bool {
+	return ctx.Query(presignSignatureParam) != ""
+}
+
+// Verifies presigned url of request to file from path, returns max size of upload
+func VerifyPresigned(ctx *gin.Context, pg *postgres.Postgres) (maxSize int64, err error) {
+	expires, err := strconv.ParseInt(ctx.Query(presignExpiresParam), 10, 64)
+	if err != nil {
+		return 0, ErrInvalidSignature
+	}
+	if value := ctx.Query(presignMaxSizeParam); value != "" {
+		maxSize, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || maxSize < 1 {
+			return 0, ErrInvalidSignature
+		}
+	}
+	signature, err := hex.DecodeString(ctx.Query(presignSignatureParam))
+	if err != nil {
+		return 0, ErrInvalidSignature
+	}
+
+	secret, err := pg.GetPresignSecret(ctx)
+	if err != nil {
+		return 0, err
+	}
+	uuid := strings.ToLower(ctx.Param("uuid"))
+	expected, _ := hex.DecodeString(presignSignature(secret, ctx.Request.Method, uuid, expires, maxSize))
+	if !hmac.Equal(signature, expected) {
+		return 0, ErrInvalidSignature
+	}
+	if time.Now().Unix() > expires {
+		return 0, ErrExpiredSignature
+	}
+	return maxSize, nil
+}
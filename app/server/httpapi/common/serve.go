@@ -0,0 +1,129 @@
+package common
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+	storagepkg "github.com/muskelo/bronze-pheasant/app/server/storage"
+	"github.com/muskelo/bronze-pheasant/lib/httpclient"
+)
+
+// Request headers forwarded to node which serves file
+var proxiedRequestHeaders = []string{
+	"Range",
+	"If-Range",
+	"If-Match",
+	"If-None-Match",
+	"If-Modified-Since",
+	"If-Unmodified-Since",
+}
+
+// Response headers copied from node which serves file
+var proxiedResponseHeaders = []string{
+	"Content-Range",
+	"Accept-Ranges",
+	"ETag",
+	"Last-Modified",
+}
+
+func ETag(file postgres.File) string {
+	if file.SHA256 == "" {
+		return `"` + file.UUID + `"`
+	}
+	return `"` + file.UUID + "-" + file.SHA256 + `"`
+}
+
+func LastModified(file postgres.File) time.Time {
+	return time.Unix(file.Created_at, 0)
+}
+
+// Sets headers describing file, they are used by conditional requests
+func SetFileHeaders(ctx *gin.Context, file postgres.File) {
+	ctx.Header("ETag", ETag(file))
+	ctx.Header("Accept-Ranges", "bytes")
+	ctx.Header("Content-Type", ContentType(file))
+	if disposition := ContentDisposition(file); disposition != "" {
+		ctx.Header("Content-Disposition", disposition)
+	}
+}
+
+// Serves local file with support of range and conditional requests
+func ServeLocalFile(ctx *gin.Context, file postgres.File, f *os.File) {
+	SetFileHeaders(ctx, file)
+	http.ServeContent(ctx.Writer, ctx.Request, "", LastModified(file), f)
+}
+
+// Serves file from local storage or from one of nodes where it's present, sent bytes
+// are counted as downloaded by client
+func ServeFile(ctx *gin.Context, pg *postgres.Postgres, storage *storagepkg.Storage, file postgres.File) {
+	f, err := storage.GetFile(file.UUID)
+	if err == nil {
+		defer f.Close()
+		ServeLocalFile(ctx, file, f)
+		countDownloaded(ctx, SourceLocal)
+		return
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		ctx.Status(500)
+		Log.Error(err.Error())
+		return
+	}
+
+	nodes, err := pg.GetNodesWithinFileV2(ctx, file.UUID, postgres.FileStateReady)
+	if err != nil {
+		ctx.Status(500)
+		Log.Error(err.Error())
+		return
+	}
+	if len(nodes) == 0 {
+		ctx.Status(404)
+		return
+	}
+
+	header := http.Header{}
+	for _, name := range proxiedRequestHeaders {
+		if value := ctx.GetHeader(name); value != "" {
+			header.Set(name, value)
+		}
+	}
+	for _, node := range nodes {
+		resp, err := httpclient.GetV1InternalFilesWithHeader(ctx.Request.Context(), node.AdvertiseAddr, file.UUID, header)
+		if err != nil {
+			Log.Error(err.Error())
+			continue
+		}
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusPartialContent, http.StatusNotModified,
+			http.StatusPreconditionFailed, http.StatusRequestedRangeNotSatisfiable:
+		default:
+			resp.Body.Close()
+			Log.Errorf("Node %v responded with %v", node.Name, resp.StatusCode)
+			continue
+		}
+		defer resp.Body.Close()
+
+		SetFileHeaders(ctx, file)
+		for _, name := range proxiedResponseHeaders {
+			if value := resp.Header.Get(name); value != "" {
+				ctx.Header(name, value)
+			}
+		}
+		if resp.StatusCode == http.StatusNotModified {
+			ctx.Status(resp.StatusCode)
+			return
+		}
+		// partial and error responses describe their own body, e.g. multipart/byteranges
+		contentType := ContentType(file)
+		if value := resp.Header.Get("Content-Type"); value != "" && resp.StatusCode != http.StatusOK {
+			contentType = value
+		}
+		ctx.DataFromReader(resp.StatusCode, resp.ContentLength, contentType, resp.Body, nil)
+		countDownloaded(ctx, SourceProxied)
+		return
+	}
+	ctx.Status(500)
+}
@@ -0,0 +1,45 @@
+package external
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+)
+
+func DeleteFile(pg *postgres.Postgres) gin.HandlerFunc {
+	type response struct {
+		Err       string `json:"err"`
+		ID        int64  `json:"id"`
+		DeletedAt int64  `json:"deleted_at"`
+	}
+
+	return func(ctx *gin.Context) {
+		resp := response{}
+
+		uuid := strings.ToLower(ctx.Param("uuid"))
+		if !common.IsValidUUID(uuid) {
+			resp.Err = "Invalid uuid"
+			ctx.JSON(400, resp)
+			return
+		}
+
+		// Mark file as deleted, nodes remove their copies on next sync
+		file, err := pg.DeleteFile(ctx, uuid)
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+		if !file.IsExist() {
+			resp.Err = "File not found"
+			ctx.JSON(404, resp)
+			return
+		}
+
+		resp.ID = file.ID
+		resp.DeletedAt = file.Deleted_at
+		ctx.JSON(200, resp)
+	}
+}
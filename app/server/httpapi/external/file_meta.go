@@ -0,0 +1,94 @@
+package external
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+	storagepkg "github.com/muskelo/bronze-pheasant/app/server/storage"
+)
+
+// Returns headers of file without downloading it
+func HeadFile(pg *postgres.Postgres) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		file, ok := getReadyFile(ctx, pg)
+		if !ok {
+			return
+		}
+
+		common.SetFileHeaders(ctx, file)
+		common.SetTagHeaders(ctx, file.Tags, common.TagHeaderPrefix)
+		ctx.Header("Content-Length", strconv.FormatInt(file.Size, 10))
+		ctx.Header("Last-Modified", common.LastModified(file).UTC().Format(http.TimeFormat))
+		ctx.Status(200)
+	}
+}
+
+// Returns metadata of file in any state with nodes where it's present
+func GetFileMeta(pg *postgres.Postgres, storage *storagepkg.Storage) gin.HandlerFunc {
+	type location struct {
+		Name          string `json:"name"`
+		AdvertiseAddr string `json:"advertise_addr"`
+		Alive         bool   `json:"alive"`
+	}
+	type response struct {
+		Err string `json:"err"`
+		fileInfo
+		// file is present on this node
+		Local     bool       `json:"local"`
+		Locations []location `json:"locations"`
+	}
+
+	return func(ctx *gin.Context) {
+		resp := response{Locations: []location{}}
+
+		uuid := strings.ToLower(ctx.Param("uuid"))
+		if !common.IsValidUUID(uuid) {
+			resp.Err = "Invalid uuid"
+			ctx.JSON(400, resp)
+			return
+		}
+		file, err := pg.GetFileByUUID(ctx, uuid)
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+		if !file.IsExist() {
+			resp.Err = "File not found"
+			ctx.JSON(404, resp)
+			return
+		}
+		nodes, err := pg.GetNodesWithinFile(ctx, file.ID)
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+		namespace := postgres.Namespace{}
+		if file.NamespaceID != 0 {
+			namespace, err = pg.GetNamespaceByID(ctx, file.NamespaceID)
+			if err != nil {
+				ctx.JSON(500, resp)
+				common.Log.Error(err.Error())
+				return
+			}
+		}
+
+		names := []string{}
+		for _, node := range nodes {
+			names = append(names, node.Name)
+			resp.Locations = append(resp.Locations, location{
+				Name:          node.Name,
+				AdvertiseAddr: node.AdvertiseAddr,
+				Alive:         node.Alive,
+			})
+		}
+		resp.fileInfo = newFileInfo(file, namespace.Name, names)
+		resp.Local = storage.IsFileExist(file.UUID)
+		ctx.JSON(200, resp)
+	}
+}
@@ -0,0 +1,177 @@
+package external
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+var fileStates = map[string]int64{
+	"new":     postgres.FileStateNew,
+	"ready":   postgres.FileStateReady,
+	"deleted": postgres.FileStateDeleted,
+}
+
+var fileStateNames = map[int64]string{
+	postgres.FileStateNew:     "new",
+	postgres.FileStateReady:   "ready",
+	postgres.FileStateDeleted: "deleted",
+}
+
+type fileInfo struct {
+	ID                int64             `json:"id"`
+	UUID              string            `json:"uuid"`
+	State             string            `json:"state"`
+	Size              int64             `json:"size"`
+	CreatedAt         int64             `json:"created_at"`
+	DeletedAt         int64             `json:"deleted_at"`
+	SHA256            string            `json:"sha256"`
+	ReplicationFactor int64             `json:"replication_factor"`
+	Namespace         string            `json:"namespace"`
+	Key               string            `json:"key"`
+	Filename          string            `json:"filename"`
+	ContentType       string            `json:"content_type"`
+	Tags              map[string]string `json:"tags"`
+	Replicas          int64             `json:"replicas"`
+	Nodes             []string          `json:"nodes"`
+}
+
+func newFileInfo(file postgres.File, namespace string, nodes []string) fileInfo {
+	return fileInfo{
+		ID:                file.ID,
+		UUID:              file.UUID,
+		State:             fileStateNames[file.State],
+		Size:              file.Size,
+		CreatedAt:         file.Created_at,
+		DeletedAt:         file.Deleted_at,
+		SHA256:            file.SHA256,
+		ReplicationFactor: file.ReplicationFactor,
+		Namespace:         namespace,
+		Key:               file.Key,
+		Filename:          file.Filename,
+		ContentType:       file.ContentType,
+		Tags:              file.Tags,
+		Replicas:          int64(len(nodes)),
+		Nodes:             nodes,
+	}
+}
+
+// Parses optional integer query parameter
+func queryInt64(ctx *gin.Context, name string) (*int64, error) {
+	value, ok := ctx.GetQuery(name)
+	if !ok {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %v", name)
+	}
+	return &n, nil
+}
+
+// Lists files page by page, next_cursor is passed as cursor to get next page and is 0 on last page
+func ListFiles(pg *postgres.Postgres) gin.HandlerFunc {
+	type response struct {
+		Err        string     `json:"err"`
+		Files      []fileInfo `json:"files"`
+		NextCursor int64      `json:"next_cursor"`
+	}
+
+	return func(ctx *gin.Context) {
+		resp := response{Files: []fileInfo{}}
+
+		filter := postgres.FileFilter{Prefix: ctx.Query("prefix")}
+		var cursor, limit *int64
+		var err error
+		for _, param := range []struct {
+			name string
+			dest **int64
+		}{
+			{"cursor", &cursor},
+			{"limit", &limit},
+			{"min_size", &filter.MinSize},
+			{"max_size", &filter.MaxSize},
+			{"min_created_at", &filter.MinCreatedAt},
+			{"max_created_at", &filter.MaxCreatedAt},
+		} {
+			*param.dest, err = queryInt64(ctx, param.name)
+			if err != nil {
+				resp.Err = err.Error()
+				ctx.JSON(400, resp)
+				return
+			}
+		}
+		after := int64(0)
+		if cursor != nil {
+			after = *cursor
+		}
+		pageSize := int64(defaultListLimit)
+		if limit != nil {
+			if *limit < 1 || *limit > maxListLimit {
+				resp.Err = "Invalid limit"
+				ctx.JSON(400, resp)
+				return
+			}
+			pageSize = *limit
+		}
+		if name, ok := ctx.GetQuery("state"); ok {
+			state, ok := fileStates[name]
+			if !ok {
+				resp.Err = "Invalid state"
+				ctx.JSON(400, resp)
+				return
+			}
+			filter.State = &state
+		}
+		if name, ok := ctx.GetQuery("namespace"); ok {
+			namespace, err := pg.GetNamespaceByName(ctx, name)
+			if err != nil {
+				ctx.JSON(500, resp)
+				common.Log.Error(err.Error())
+				return
+			}
+			if !namespace.IsExist() {
+				resp.Err = "Namespace not found"
+				ctx.JSON(404, resp)
+				return
+			}
+			filter.NamespaceID = &namespace.ID
+		}
+		// tag.<key>=<value> matches files having all given tags
+		for name, values := range ctx.Request.URL.Query() {
+			key, ok := strings.CutPrefix(name, tagFieldPrefix)
+			if !ok {
+				continue
+			}
+			if filter.Tags == nil {
+				filter.Tags = map[string]string{}
+			}
+			filter.Tags[strings.ToLower(key)] = values[0]
+		}
+
+		// one more file shows if there is next page
+		files, err := pg.ListFiles(ctx, filter, after, pageSize+1)
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+		if int64(len(files)) > pageSize {
+			files = files[:pageSize]
+			resp.NextCursor = files[len(files)-1].ID
+		}
+		for _, file := range files {
+			resp.Files = append(resp.Files, newFileInfo(file.File, file.Namespace, file.Nodes))
+		}
+		ctx.JSON(200, resp)
+	}
+}
@@ -0,0 +1,125 @@
+package external
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+)
+
+type namespaceResponse struct {
+	Name      string `json:"name"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+func CreateNamespace(pg *postgres.Postgres) gin.HandlerFunc {
+	type response struct {
+		Err string `json:"err"`
+		namespaceResponse
+	}
+
+	return func(ctx *gin.Context) {
+		resp := response{}
+
+		name := ctx.Param("namespace")
+		if !common.IsValidNamespace(name) {
+			resp.Err = "Invalid namespace"
+			ctx.JSON(400, resp)
+			return
+		}
+		namespace, err := pg.CreateNamespace(ctx, name)
+		if errors.Is(err, postgres.ErrNamespaceExist) {
+			resp.Err = err.Error()
+			ctx.JSON(409, resp)
+			return
+		}
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+
+		resp.Name = namespace.Name
+		resp.CreatedAt = namespace.Created_at
+		ctx.JSON(200, resp)
+	}
+}
+
+func GetNamespaces(pg *postgres.Postgres) gin.HandlerFunc {
+	type response struct {
+		Err        string              `json:"err"`
+		Namespaces []namespaceResponse `json:"namespaces"`
+	}
+
+	return func(ctx *gin.Context) {
+		resp := response{Namespaces: []namespaceResponse{}}
+
+		namespaces, err := pg.GetNamespaces(ctx)
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+
+		for _, namespace := range namespaces {
+			resp.Namespaces = append(resp.Namespaces, namespaceResponse{
+				Name:      namespace.Name,
+				CreatedAt: namespace.Created_at,
+			})
+		}
+		ctx.JSON(200, resp)
+	}
+}
+
+// Deletes namespace, only namespace without files can be deleted
+func DeleteNamespace(pg *postgres.Postgres) gin.HandlerFunc {
+	type response struct {
+		Err string `json:"err"`
+	}
+
+	return func(ctx *gin.Context) {
+		resp := response{}
+
+		namespace, ok := getNamespace(ctx, pg)
+		if !ok {
+			return
+		}
+		err := pg.DeleteNamespace(ctx, namespace.ID)
+		if errors.Is(err, postgres.ErrNamespaceNotEmpty) {
+			resp.Err = err.Error()
+			ctx.JSON(409, resp)
+			return
+		}
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+		ctx.JSON(200, resp)
+	}
+}
+
+// Returns namespace from path or sends response if it isn't found
+func getNamespace(ctx *gin.Context, pg *postgres.Postgres) (postgres.Namespace, bool) {
+	type response struct {
+		Err string `json:"err"`
+	}
+
+	name := ctx.Param("namespace")
+	if !common.IsValidNamespace(name) {
+		ctx.JSON(400, response{Err: "Invalid namespace"})
+		return postgres.Namespace{}, false
+	}
+	namespace, err := pg.GetNamespaceByName(ctx, name)
+	if err != nil {
+		ctx.JSON(500, response{})
+		common.Log.Error(err.Error())
+		return namespace, false
+	}
+	if !namespace.IsExist() {
+		ctx.JSON(404, response{Err: "Namespace not found"})
+		return namespace, false
+	}
+	return namespace, true
+}
@@ -0,0 +1,106 @@
+package external
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	uuidp "github.com/google/uuid"
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+	storagepkg "github.com/muskelo/bronze-pheasant/app/server/storage"
+)
+
+// Objects are files addressed by namespace and key, every upload creates file
+// with new uuid and replaces previous version of object
+
+// Returns namespace and key from path or sends response if they are invalid
+func getNamespaceAndKey(ctx *gin.Context, pg *postgres.Postgres) (postgres.Namespace, string, bool) {
+	type response struct {
+		Err string `json:"err"`
+	}
+
+	key := strings.TrimPrefix(ctx.Param("key"), "/")
+	if !common.IsValidKey(key) {
+		ctx.JSON(400, response{Err: "Invalid key"})
+		return postgres.Namespace{}, key, false
+	}
+	namespace, ok := getNamespace(ctx, pg)
+	return namespace, key, ok
+}
+
+func UploadObject(nodeID int64, pg *postgres.Postgres, storage *storagepkg.Storage) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		namespace, key, ok := getNamespaceAndKey(ctx, pg)
+		if !ok {
+			return
+		}
+
+		uuid := uuidp.NewString()
+		createFile := func(replicationFactor int64, metadata postgres.FileMetadata) (postgres.File, error) {
+			return pg.CreateObject(ctx, uuid, namespace.ID, key, replicationFactor, metadata)
+		}
+		commitFile := func(file postgres.File, size int64, checksum string) (postgres.File, error) {
+			return pg.CommitObject(ctx, file.ID, size, checksum)
+		}
+		uploadFile(ctx, nodeID, pg, storage, uuid, 0, createFile, commitFile)
+	}
+}
+
+func DownloadObject(pg *postgres.Postgres, storage *storagepkg.Storage) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		namespace, key, ok := getNamespaceAndKey(ctx, pg)
+		if !ok {
+			return
+		}
+
+		file, err := pg.GetObject(ctx, namespace.ID, key)
+		if err != nil {
+			ctx.Status(500)
+			common.Log.Error(err.Error())
+			return
+		}
+		if !file.IsExist() {
+			ctx.Status(404)
+			return
+		}
+
+		common.SetTagHeaders(ctx, file.Tags, common.TagHeaderPrefix)
+		common.ServeFile(ctx, pg, storage, file)
+	}
+}
+
+func DeleteObject(pg *postgres.Postgres) gin.HandlerFunc {
+	type response struct {
+		Err       string `json:"err"`
+		ID        int64  `json:"id"`
+		UUID      string `json:"uuid"`
+		DeletedAt int64  `json:"deleted_at"`
+	}
+
+	return func(ctx *gin.Context) {
+		resp := response{}
+
+		namespace, key, ok := getNamespaceAndKey(ctx, pg)
+		if !ok {
+			return
+		}
+
+		// Mark file as deleted, nodes remove their copies on next sync
+		file, err := pg.DeleteObject(ctx, namespace.ID, key)
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+		if !file.IsExist() {
+			resp.Err = "Object not found"
+			ctx.JSON(404, resp)
+			return
+		}
+
+		resp.ID = file.ID
+		resp.UUID = file.UUID
+		resp.DeletedAt = file.Deleted_at
+		ctx.JSON(200, resp)
+	}
+}
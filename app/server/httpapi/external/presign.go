@@ -0,0 +1,90 @@
+package external
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+)
+
+const (
+	defaultPresignExpiresIn = 3600
+	maxPresignExpiresIn     = 7 * 24 * 3600
+)
+
+// Returns presigned url for request with method to file from path,
+// GET presigns download and POST presigns upload
+func PresignFile(pg *postgres.Postgres, method string) gin.HandlerFunc {
+	type response struct {
+		Err       string `json:"err"`
+		URL       string `json:"url"`
+		ExpiresAt int64  `json:"expires_at"`
+	}
+
+	return func(ctx *gin.Context) {
+		resp := response{}
+
+		uuid := strings.ToLower(ctx.Param("uuid"))
+		if !common.IsValidUUID(uuid) {
+			resp.Err = "Invalid uuid"
+			ctx.JSON(400, resp)
+			return
+		}
+		expiresIn, err := queryInt64(ctx, "expires_in")
+		if err != nil || (expiresIn != nil && (*expiresIn < 1 || *expiresIn > maxPresignExpiresIn)) {
+			resp.Err = "Invalid expires_in"
+			ctx.JSON(400, resp)
+			return
+		}
+		maxSize, err := queryInt64(ctx, "max_size")
+		if err != nil || (maxSize != nil && (*maxSize < 1 || method != "POST")) {
+			resp.Err = "Invalid max_size"
+			ctx.JSON(400, resp)
+			return
+		}
+
+		secret, err := pg.GetPresignSecret(ctx)
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+		resp.ExpiresAt = time.Now().Unix() + defaultPresignExpiresIn
+		if expiresIn != nil {
+			resp.ExpiresAt = time.Now().Unix() + *expiresIn
+		}
+		limit := int64(0)
+		if maxSize != nil {
+			limit = *maxSize
+		}
+		query := common.PresignQuery(secret, method, uuid, resp.ExpiresAt, limit)
+		resp.URL = "/api/v1/external/files/" + uuid + "?" + query.Encode()
+		ctx.JSON(200, resp)
+	}
+}
+
+// Verifies presigned url of request if it has one or sends response if it's invalid,
+// returns max size of upload, 0 means size isn't limited
+func checkPresigned(ctx *gin.Context, pg *postgres.Postgres) (int64, bool) {
+	type response struct {
+		Err string `json:"err"`
+	}
+
+	if !common.IsPresigned(ctx) {
+		return 0, true
+	}
+	maxSize, err := common.VerifyPresigned(ctx, pg)
+	if errors.Is(err, common.ErrInvalidSignature) || errors.Is(err, common.ErrExpiredSignature) {
+		ctx.JSON(403, response{Err: err.Error()})
+		return 0, false
+	}
+	if err != nil {
+		ctx.JSON(500, response{})
+		common.Log.Error(err.Error())
+		return 0, false
+	}
+	return maxSize, true
+}
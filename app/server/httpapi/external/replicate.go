@@ -0,0 +1,165 @@
+package external
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+	"github.com/muskelo/bronze-pheasant/lib/httpclient"
+)
+
+type replicaResult struct {
+	Err    string `json:"err"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Node which doesn't accept next chunk of upload or doesn't confirm its copy during this time
+// is dropped from write quorum, so stalled node doesn't block upload
+const replicaTimeout = 30 * time.Second
+
+// Copy of uploaded file which is streamed to another node
+type replica struct {
+	node   postgres.Node
+	pw     *io.PipeWriter
+	failed bool
+	// aborts request to node
+	cancel context.CancelFunc
+	result chan replicaResult
+}
+
+// Writer which streams uploaded file to several nodes at once, writes to failed nodes are skipped
+type replicator struct {
+	replicas []*replica
+	timeout  time.Duration
+}
+
+func newReplicator(uuid string, nodes []postgres.Node, timeout time.Duration) *replicator {
+	rep := &replicator{timeout: timeout}
+	for _, node := range nodes {
+		pr, pw := io.Pipe()
+		ctx, cancel := context.WithCancel(context.Background())
+		r := &replica{node: node, pw: pw, cancel: cancel, result: make(chan replicaResult, 1)}
+		go func() {
+			result := sendReplica(ctx, node, uuid, pr)
+			// unblock writer if node stopped reading
+			pr.CloseWithError(io.ErrClosedPipe)
+			r.result <- result
+		}()
+		rep.replicas = append(rep.replicas, r)
+	}
+	return rep
+}
+
+func sendReplica(ctx context.Context, node postgres.Node, uuid string, body io.Reader) (result replicaResult) {
+	resp, err := httpclient.PutV1InternalFiles(ctx, node.AdvertiseAddr, uuid, body)
+	if err != nil {
+		result.Err = err.Error()
+		return
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		result.Err = err.Error()
+		return
+	}
+	if resp.StatusCode != 200 && result.Err == "" {
+		result.Err = fmt.Sprintf("Unexpected status code %v", resp.StatusCode)
+	}
+	return
+}
+
+// Writes p to all nodes in parallel, node which doesn't accept it in time is dropped
+func (rep *replicator) Write(p []byte) (int, error) {
+	wg := sync.WaitGroup{}
+	for _, r := range rep.replicas {
+		if r.failed {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// aborted request closes pipe, so write returns and p isn't used after Write
+			timer := time.AfterFunc(rep.timeout, r.cancel)
+			n, err := r.pw.Write(p)
+			if !timer.Stop() {
+				common.Log.Errorf("Node %v is too slow, it's dropped from write quorum", r.node.Name)
+			}
+			common.UploadedBytes.WithLabelValues(common.SourceProxied).Add(float64(n))
+			if err != nil {
+				r.failed = true
+			}
+		}()
+	}
+	wg.Wait()
+	return len(p), nil
+}
+
+// Finishes streaming and returns number of nodes which saved the same file as local one and
+// nodes which didn't, copies on them must be removed. localErr aborts streaming.
+func (rep *replicator) finish(localErr error, size int64, checksum string) (confirmed int64, rejected []postgres.Node) {
+	for _, r := range rep.replicas {
+		if localErr != nil {
+			r.pw.CloseWithError(localErr)
+		} else {
+			r.pw.Close()
+		}
+	}
+	deadline := time.After(rep.timeout)
+	for _, r := range rep.replicas {
+		var result replicaResult
+		select {
+		case result = <-r.result:
+		case <-deadline:
+			r.cancel()
+			result = <-r.result
+		}
+		r.cancel()
+		if localErr != nil {
+			continue
+		}
+		if result.Err != "" {
+			common.Log.Errorf("Failed replicate to node %v: %v", r.node.Name, result.Err)
+			rejected = append(rejected, r.node)
+			continue
+		}
+		if result.Size != size || result.SHA256 != checksum {
+			common.Log.Errorf("Node %v saved different file (%v, %v)", r.node.Name, result.Size, result.SHA256)
+			rejected = append(rejected, r.node)
+			continue
+		}
+		confirmed++
+	}
+	return
+}
+
+// Unregisters copies which weren't confirmed and asks nodes to remove them, failures are only logged
+func rejectReplicas(ctx context.Context, pg *postgres.Postgres, file postgres.File, nodes []postgres.Node) {
+	for _, node := range nodes {
+		err := pg.RemoveFileFromNode(ctx, node.ID, file.ID)
+		if err != nil {
+			common.Log.Errorf("Failed unregister rejected %v on node %v: %v", file.UUID, node.Name, err)
+		}
+	}
+	removeReplicas(file.UUID, nodes)
+}
+
+// Removes copies of aborted upload from nodes, failures are only logged
+func removeReplicas(uuid string, nodes []postgres.Node) {
+	for _, node := range nodes {
+		resp, err := httpclient.DeleteV1InternalFiles(node.AdvertiseAddr, uuid)
+		if err != nil {
+			common.Log.Errorf("Failed remove aborted %v from node %v: %v", uuid, node.Name, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			common.Log.Errorf("Failed remove aborted %v from node %v: unexpected status code %v", uuid, node.Name, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package external
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+	"github.com/stretchr/testify/require"
+)
+
+// Returns node which saves body and reports its size and checksum, corrupt flips reported checksum
+func newReplicaNode(t *testing.T, name string, corrupt bool) postgres.Node {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hash := sha256.New()
+		size, _ := io.Copy(hash, r.Body)
+		checksum := hex.EncodeToString(hash.Sum(nil))
+		if corrupt {
+			checksum = "bad"
+		}
+		json.NewEncoder(w).Encode(replicaResult{Size: size, SHA256: checksum})
+	}))
+	t.Cleanup(server.Close)
+	return postgres.Node{Name: name, AdvertiseAddr: server.URL}
+}
+
+// Returns node which never reads body
+func newStalledNode(t *testing.T, name string) postgres.Node {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(func() {
+		close(release)
+		server.Close()
+	})
+	return postgres.Node{Name: name, AdvertiseAddr: server.URL}
+}
+
+func TestReplicator(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	hash := sha256.Sum256(data)
+	checksum := hex.EncodeToString(hash[:])
+
+	t.Log("Stalled and corrupted nodes are rejected")
+	{
+		good := newReplicaNode(t, "good", false)
+		corrupted := newReplicaNode(t, "corrupted", true)
+		stalled := newStalledNode(t, "stalled")
+
+		rep := newReplicator("3f8b1b8e-5b7a-4f0e-9d2f-1b7f0c2a9e11", []postgres.Node{good, corrupted, stalled}, 200*time.Millisecond)
+		started := time.Now()
+		_, err := io.Copy(rep, bytes.NewReader(data))
+		require.NoError(t, err)
+		confirmed, rejected := rep.finish(nil, int64(len(data)), checksum)
+
+		require.Less(t, time.Since(started), 5*time.Second, "Stalled node must not block upload")
+		require.Equal(t, int64(1), confirmed)
+		require.Len(t, rejected, 2)
+		require.ElementsMatch(t, []string{"corrupted", "stalled"}, []string{rejected[0].Name, rejected[1].Name})
+	}
+}
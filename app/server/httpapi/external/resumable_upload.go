@@ -0,0 +1,412 @@
+package external
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	uuidp "github.com/google/uuid"
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+	storagepkg "github.com/muskelo/bronze-pheasant/app/server/storage"
+)
+
+// Resumable uploads implement tus protocol 1.0.0 with creation, termination and expiration extensions
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,creation-with-upload,termination,expiration"
+	uploadsPath   = "/api/v1/external/uploads/"
+)
+
+// Uploads which are being patched now, tus requires exclusive access to upload
+var (
+	patchingUploads   = map[int64]struct{}{}
+	patchingUploadsMu sync.Mutex
+)
+
+func lockUpload(fileID int64) bool {
+	patchingUploadsMu.Lock()
+	defer patchingUploadsMu.Unlock()
+
+	if _, ok := patchingUploads[fileID]; ok {
+		return false
+	}
+	patchingUploads[fileID] = struct{}{}
+	return true
+}
+
+func unlockUpload(fileID int64) {
+	patchingUploadsMu.Lock()
+	defer patchingUploadsMu.Unlock()
+
+	delete(patchingUploads, fileID)
+}
+
+// Checks Tus-Resumable header and sets common headers, returns false if response was sent
+func tusPrepare(ctx *gin.Context) bool {
+	ctx.Header("Tus-Resumable", tusVersion)
+	ctx.Header("Cache-Control", "no-store")
+	if ctx.GetHeader("Tus-Resumable") != tusVersion {
+		ctx.Header("Tus-Version", tusVersion)
+		ctx.Status(412)
+		return false
+	}
+	return true
+}
+
+func setUploadExpires(ctx *gin.Context, upload postgres.Upload) {
+	ctx.Header("Upload-Expires", time.Unix(upload.ExpiresAt, 0).UTC().Format(http.TimeFormat))
+}
+
+// Parses Upload-Metadata header, values are base64 encoded
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// Converts Upload-Metadata to metadata of file, keys used by common tus clients
+// are mapped to filename and content type, other keys except uuid become tags
+func uploadFileMetadata(metadata map[string]string) postgres.FileMetadata {
+	fileMetadata := postgres.FileMetadata{Tags: map[string]string{}}
+	for key, value := range metadata {
+		switch strings.ToLower(key) {
+		case "uuid":
+		case "filename", "name":
+			fileMetadata.Filename = value
+		case "filetype", "type", "content-type":
+			fileMetadata.ContentType = value
+		default:
+			fileMetadata.Tags[strings.ToLower(key)] = value
+		}
+	}
+	return fileMetadata
+}
+
+func UploadOptions() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Header("Tus-Resumable", tusVersion)
+		ctx.Header("Tus-Version", tusVersion)
+		ctx.Header("Tus-Extension", tusExtensions)
+		ctx.Status(204)
+	}
+}
+
+// Appends body to upload at offset and finishes it when all bytes are received,
+// returns false if error response was sent
+func patchUpload(ctx *gin.Context, nodeID int64, pg *postgres.Postgres, storage *storagepkg.Storage, upload postgres.Upload, offset int64) bool {
+	if upload.NodeID != nodeID {
+		ctx.String(409, "Upload is stored on another node")
+		return false
+	}
+	if !lockUpload(upload.FileID) {
+		ctx.String(423, "Upload is locked")
+		return false
+	}
+	defer unlockUpload(upload.FileID)
+
+	// offset could be changed by other request before lock was taken
+	upload, err := pg.GetUploadByUUID(ctx, upload.FileUUID)
+	if err != nil {
+		ctx.Status(500)
+		common.Log.Error(err.Error())
+		return false
+	}
+	if !upload.IsExist() {
+		ctx.Status(404)
+		return false
+	}
+	if offset != upload.Offset {
+		ctx.String(409, "Upload-Offset mismatch")
+		return false
+	}
+
+	// node crashed while upload was finished, data is moved back so upload can be finished again
+	if upload.IsComplete() {
+		err = storage.DemoteUploadfile(upload.FileUUID)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			ctx.Status(500)
+			common.Log.Error(err.Error())
+			return false
+		}
+	}
+
+	src := io.LimitReader(ctx.Request.Body, upload.Length-upload.Offset)
+	written, err := storage.AppendUploadfile(upload.FileUUID, upload.Offset, src)
+	if errors.Is(err, storagepkg.ErrOffsetMismatch) || errors.Is(err, os.ErrNotExist) {
+		ctx.String(409, "Upload data is lost")
+		common.Log.Errorf("Upload %v is inconsistent: %v", upload.FileUUID, err)
+		return false
+	}
+	if written > 0 {
+		common.UploadedBytes.WithLabelValues(common.SourceLocal).Add(float64(written))
+		updateErr := pg.UpdateUploadOffset(ctx, upload.FileID, upload.Offset+written, upload.Offset)
+		if updateErr != nil {
+			ctx.Status(500)
+			common.Log.Error(updateErr.Error())
+			return false
+		}
+		upload.Offset += written
+	}
+	if err != nil {
+		ctx.Status(500)
+		common.Log.Error(err.Error())
+		return false
+	}
+
+	if upload.IsComplete() {
+		err = finishUpload(ctx, nodeID, pg, storage, upload)
+		if err != nil {
+			ctx.Status(500)
+			common.Log.Error(err.Error())
+			return false
+		}
+	}
+	ctx.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if !upload.IsComplete() {
+		setUploadExpires(ctx, upload)
+	}
+	return true
+}
+
+// Moves complete upload to datadir and marks file ready, data is moved back to upload
+// if file can't be marked ready, so retried PATCH can finish it
+func finishUpload(ctx *gin.Context, nodeID int64, pg *postgres.Postgres, storage *storagepkg.Storage, upload postgres.Upload) error {
+	size, checksum, err := storage.PromoteUploadfile(upload.FileUUID)
+	if err != nil {
+		return err
+	}
+	file, err := pg.FinishUpload(ctx, upload.FileID, nodeID, size, checksum)
+	if err == nil && !file.IsExist() {
+		// upload was terminated or expired, nothing can finish it anymore
+		removeErr := storage.RemoveFile(upload.FileUUID)
+		if removeErr != nil {
+			common.Log.Errorf("Failed remove data of aborted upload %v: %v", upload.FileUUID, removeErr)
+		}
+		return fmt.Errorf("File %v of upload doesn't exist", upload.FileUUID)
+	}
+	if err != nil {
+		demoteErr := storage.DemoteUploadfile(upload.FileUUID)
+		if demoteErr != nil {
+			common.Log.Errorf("Failed move back data of upload %v: %v", upload.FileUUID, demoteErr)
+		}
+		return err
+	}
+	err = pg.NotifyFileReady(ctx, file.UUID)
+	if err != nil {
+		common.Log.Error(err.Error())
+	}
+	return nil
+}
+
+// Creates upload which is removed if it isn't finished during lifetime
+func CreateUpload(nodeID int64, pg *postgres.Postgres, storage *storagepkg.Storage, lifetime time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !tusPrepare(ctx) {
+			return
+		}
+
+		length, err := strconv.ParseInt(ctx.GetHeader("Upload-Length"), 10, 64)
+		if err != nil || length < 0 {
+			ctx.String(400, "Invalid Upload-Length")
+			return
+		}
+		metadata, err := parseUploadMetadata(ctx.GetHeader("Upload-Metadata"))
+		if err != nil {
+			ctx.String(400, "Invalid Upload-Metadata")
+			return
+		}
+		uuid := strings.ToLower(metadata["uuid"])
+		if uuid == "" {
+			uuid = uuidp.NewString()
+		}
+		if !common.IsValidUUID(uuid) {
+			ctx.String(400, "Invalid uuid")
+			return
+		}
+
+		fileMetadata := uploadFileMetadata(metadata)
+		for key, value := range common.TagsFromHeaders(ctx.Request.Header, common.TagHeaderPrefix) {
+			fileMetadata.Tags[key] = value
+		}
+		err = common.CheckMetadata(fileMetadata)
+		if err != nil {
+			ctx.String(400, err.Error())
+			return
+		}
+
+		file, err := pg.CreateFile(ctx, uuid, 0, 0, fileMetadata)
+		if err != nil {
+			ctx.Status(500)
+			common.Log.Error(err.Error())
+			return
+		}
+		err = storage.CreateUploadfile(uuid)
+		if err != nil {
+			abortUpload(ctx, pg, storage, file)
+			ctx.Status(500)
+			common.Log.Error(err.Error())
+			return
+		}
+		upload, err := pg.CreateUpload(ctx, file.ID, nodeID, length, lifetime)
+		if err != nil {
+			abortUpload(ctx, pg, storage, file)
+			ctx.Status(500)
+			common.Log.Error(err.Error())
+			return
+		}
+
+		ctx.Header("Location", uploadsPath+uuid)
+		ctx.Header("Upload-Offset", "0")
+		setUploadExpires(ctx, upload)
+		// creation-with-upload and empty files
+		if ctx.GetHeader("Content-Type") == "application/offset+octet-stream" || length == 0 {
+			if !patchUpload(ctx, nodeID, pg, storage, upload, 0) {
+				return
+			}
+		}
+		ctx.Status(201)
+	}
+}
+
+// Returns upload from path or sends response if it isn't found
+func getUpload(ctx *gin.Context, pg *postgres.Postgres) (postgres.Upload, bool) {
+	uuid := strings.ToLower(ctx.Param("uuid"))
+	if !common.IsValidUUID(uuid) {
+		ctx.Status(404)
+		return postgres.Upload{}, false
+	}
+	upload, err := pg.GetUploadByUUID(ctx, uuid)
+	if err != nil {
+		ctx.Status(500)
+		common.Log.Error(err.Error())
+		return upload, false
+	}
+	if !upload.IsExist() {
+		ctx.Status(404)
+		return upload, false
+	}
+	// expired upload is removed by storage gc of its node
+	if upload.IsExpired() {
+		ctx.Status(410)
+		return upload, false
+	}
+	return upload, true
+}
+
+// Removes file of upload which can't be created, so uuid can be used again
+func abortUpload(ctx *gin.Context, pg *postgres.Postgres, storage *storagepkg.Storage, file postgres.File) {
+	err := pg.AbortFile(ctx, file.ID)
+	if err != nil {
+		common.Log.Errorf("Failed abort upload of %v: %v", file.UUID, err)
+		return
+	}
+	err = storage.RemoveUploadfile(file.UUID)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		common.Log.Errorf("Failed remove data of aborted upload %v: %v", file.UUID, err)
+	}
+}
+
+// Reports offset of upload, works on any node
+func GetUploadOffset(pg *postgres.Postgres) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !tusPrepare(ctx) {
+			return
+		}
+		upload, ok := getUpload(ctx, pg)
+		if !ok {
+			return
+		}
+
+		ctx.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		ctx.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		setUploadExpires(ctx, upload)
+		ctx.Status(200)
+	}
+}
+
+func PatchUpload(nodeID int64, pg *postgres.Postgres, storage *storagepkg.Storage) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !tusPrepare(ctx) {
+			return
+		}
+		if ctx.GetHeader("Content-Type") != "application/offset+octet-stream" {
+			ctx.Status(415)
+			return
+		}
+		offset, err := strconv.ParseInt(ctx.GetHeader("Upload-Offset"), 10, 64)
+		if err != nil {
+			ctx.String(400, "Invalid Upload-Offset")
+			return
+		}
+		upload, ok := getUpload(ctx, pg)
+		if !ok {
+			return
+		}
+		if offset != upload.Offset {
+			ctx.String(409, "Upload-Offset mismatch")
+			return
+		}
+
+		if patchUpload(ctx, nodeID, pg, storage, upload, offset) {
+			ctx.Status(204)
+		}
+	}
+}
+
+// Terminates upload, its data is removed on the node where it's stored
+func TerminateUpload(nodeID int64, pg *postgres.Postgres, storage *storagepkg.Storage) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !tusPrepare(ctx) {
+			return
+		}
+		upload, ok := getUpload(ctx, pg)
+		if !ok {
+			return
+		}
+		if upload.NodeID != nodeID {
+			ctx.String(409, "Upload is stored on another node")
+			return
+		}
+		if !lockUpload(upload.FileID) {
+			ctx.String(423, "Upload is locked")
+			return
+		}
+		defer unlockUpload(upload.FileID)
+
+		err := storage.RemoveUploadfile(upload.FileUUID)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			ctx.Status(500)
+			common.Log.Error(err.Error())
+			return
+		}
+		// upload is removed together with file, so uuid can be used again
+		err = pg.AbortFile(ctx, upload.FileID)
+		if err != nil {
+			ctx.Status(500)
+			common.Log.Error(err.Error())
+			return
+		}
+		ctx.Status(204)
+	}
+}
@@ -1,93 +1,276 @@
 package external
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
 	"github.com/muskelo/bronze-pheasant/app/server/postgres"
 	storagepkg "github.com/muskelo/bronze-pheasant/app/server/storage"
+	"github.com/muskelo/bronze-pheasant/app/server/syncm"
 )
 
-func UploadFile(nodeID int64, pg *postgres.Postgres, storage *storagepkg.Storage) gin.HandlerFunc {
-	type response struct {
-		Err       string `json:"err"`
-		ID        int64  `json:"id"`
-		CreatedAt int64  `json:"created_at"`
-		Size      int64  `json:"size"`
-	}
+const (
+	// prefix of names of form fields with tags
+	tagFieldPrefix  = "tag."
+	maxTagFieldSize = 1024 + 1
+)
+
+type uploadResponse struct {
+	Err       string `json:"err"`
+	ID        int64  `json:"id"`
+	UUID      string `json:"uuid"`
+	CreatedAt int64  `json:"created_at"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	Replicas  int64  `json:"replicas"`
+}
 
+func UploadFile(nodeID int64, pg *postgres.Postgres, storage *storagepkg.Storage) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		resp := response{}
+		resp := uploadResponse{}
 
-		// Parse uuid and part from multipart form
 		uuid := strings.ToLower(ctx.Param("uuid"))
 		if !common.IsValidUUID(uuid) {
 			resp.Err = fmt.Sprintf("Invalid uuid")
 			ctx.JSON(400, resp)
 			return
 		}
-		mr, err := ctx.Request.MultipartReader()
-		if err != nil {
-			resp.Err = err.Error()
-			ctx.JSON(400, resp)
+
+		maxSize, ok := checkPresigned(ctx, pg)
+		if !ok {
 			return
 		}
-		part, err := mr.NextPart()
-		if err == io.EOF {
-			resp.Err = fmt.Sprintf("Required one file")
+
+		createFile := func(replicationFactor int64, metadata postgres.FileMetadata) (postgres.File, error) {
+			return pg.CreateFile(ctx, uuid, 0, replicationFactor, metadata)
+		}
+		commitFile := func(file postgres.File, size int64, checksum string) (postgres.File, error) {
+			return pg.UpdateFile(ctx, file.ID, postgres.FileStateReady, size, checksum)
+		}
+		uploadFile(ctx, nodeID, pg, storage, uuid, maxSize, createFile, commitFile)
+	}
+}
+
+var errFileTooLarge = errors.New("File is too large")
+
+// Fails reading when more than left bytes are read
+type sizeLimitReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	if l.left < 0 {
+		return n, errFileTooLarge
+	}
+	return n, err
+}
+
+// Receives file from multipart form and saves it locally and on peers required by write quorum.
+// maxSize limits size of file if it isn't 0, createFile creates file in postgres, commitFile makes it ready.
+func uploadFile(
+	ctx *gin.Context,
+	nodeID int64,
+	pg *postgres.Postgres,
+	storage *storagepkg.Storage,
+	uuid string,
+	maxSize int64,
+	createFile func(replicationFactor int64, metadata postgres.FileMetadata) (postgres.File, error),
+	commitFile func(file postgres.File, size int64, checksum string) (postgres.File, error),
+) {
+	resp := uploadResponse{}
+
+	replicationFactor := int64(0)
+	if replicas := ctx.Query("replicas"); replicas != "" {
+		n, err := strconv.ParseInt(replicas, 10, 64)
+		if err != nil || n < 0 {
+			resp.Err = "Invalid replicas"
 			ctx.JSON(400, resp)
 			return
 		}
-		if err != nil {
-			resp.Err = fmt.Sprintf("Error reading multipart section: %v\n", err)
+		replicationFactor = n
+	}
+	// number of nodes which must save file before response
+	writeQuorum := int64(1)
+	if w := ctx.DefaultQuery("w", ctx.GetHeader("X-Write-Quorum")); w != "" {
+		n, err := strconv.ParseInt(w, 10, 64)
+		if err != nil || n < 1 {
+			resp.Err = "Invalid write quorum"
 			ctx.JSON(400, resp)
 			return
 		}
-		defer part.Close()
-
-		// Create file in postgresql
-		file, err := pg.CreateFile(ctx, uuid, 0)
+		writeQuorum = n
+	}
+	var peers []postgres.Node
+	if writeQuorum > 1 {
+		activeNodes, err := pg.GetActiveNodes(ctx)
 		if err != nil {
 			ctx.JSON(500, resp)
 			common.Log.Error(err.Error())
 			return
 		}
-
-		// Write file on disk
-		size, err := storage.WriteFile(uuid, part)
-		if err == os.ErrExist {
-			resp.Err = "File already exist on disk"
-			ctx.JSON(409, resp)
+		for _, node := range syncm.PlacementOrder(uuid, activeNodes) {
+			if node.ID != nodeID && int64(len(peers)) < writeQuorum-1 {
+				peers = append(peers, node)
+			}
+		}
+		if int64(len(peers)) < writeQuorum-1 {
+			resp.Err = "Not enough active nodes for write quorum"
+			ctx.JSON(503, resp)
+			return
+		}
+	}
+	mr, err := ctx.Request.MultipartReader()
+	if err != nil {
+		resp.Err = err.Error()
+		ctx.JSON(400, resp)
+		return
+	}
+	// Tags can be sent as form fields "tag.<key>" before file
+	metadata := postgres.FileMetadata{Tags: common.TagsFromHeaders(ctx.Request.Header, common.TagHeaderPrefix)}
+	var part *multipart.Part
+	for {
+		part, err = mr.NextPart()
+		if err == io.EOF {
+			resp.Err = fmt.Sprintf("Required one file")
+			ctx.JSON(400, resp)
 			return
 		}
 		if err != nil {
-			ctx.JSON(500, resp)
-			common.Log.Error(err.Error())
+			resp.Err = fmt.Sprintf("Error reading multipart section: %v\n", err)
+			ctx.JSON(400, resp)
 			return
 		}
-
-		// Update info about file in postgres
-		err = pg.AddFileToNode(ctx, nodeID, file.ID)
+		key, ok := strings.CutPrefix(part.FormName(), tagFieldPrefix)
+		if !ok || part.FileName() != "" {
+			break
+		}
+		value, err := io.ReadAll(io.LimitReader(part, maxTagFieldSize))
 		if err != nil {
-			ctx.JSON(500, resp)
-			common.Log.Error(err.Error())
+			resp.Err = fmt.Sprintf("Error reading multipart section: %v\n", err)
+			ctx.JSON(400, resp)
 			return
 		}
-		file, err = pg.UpdateFile(ctx, file.ID, 1, size)
+		metadata.Tags[strings.ToLower(key)] = string(value)
+	}
+	defer part.Close()
+	metadata.Filename = part.FileName()
+	metadata.ContentType = part.Header.Get("Content-Type")
+	err = common.CheckMetadata(metadata)
+	if err != nil {
+		resp.Err = err.Error()
+		ctx.JSON(400, resp)
+		return
+	}
+
+	// Create file in postgresql
+	file, err := createFile(replicationFactor, metadata)
+	if err != nil {
+		ctx.JSON(500, resp)
+		common.Log.Error(err.Error())
+		return
+	}
+	// Failed upload is removed everywhere, so client can retry it with the same uuid.
+	// Local data isn't removed if it existed before upload.
+	abort := func(removeLocal bool) {
+		err := pg.AbortFile(ctx, file.ID)
 		if err != nil {
-			ctx.JSON(500, resp)
-			common.Log.Error(err.Error())
+			common.Log.Errorf("Failed abort upload of %v: %v", uuid, err)
 			return
 		}
+		if removeLocal {
+			err = storage.RemoveFile(uuid)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				common.Log.Errorf("Failed remove aborted %v: %v", uuid, err)
+			}
+		}
+		removeReplicas(uuid, peers)
+	}
 
-		// Send response
-		resp.ID = file.ID
-		resp.Size = file.Size
-		resp.CreatedAt = file.Created_at
-		ctx.JSON(200, resp)
+	// Write file on disk, copies are streamed to peers at the same time
+	var src io.Reader = part
+	if maxSize > 0 {
+		src = &sizeLimitReader{r: part, left: maxSize}
+	}
+	var rep *replicator
+	if len(peers) > 0 {
+		rep = newReplicator(uuid, peers, replicaTimeout)
+		src = io.TeeReader(src, rep)
+	}
+	size, checksum, err := storage.WriteFile(uuid, src)
+	if rep != nil {
+		var rejected []postgres.Node
+		resp.Replicas, rejected = rep.finish(err, size, checksum)
+		if len(rejected) > 0 {
+			rejectReplicas(ctx, pg, file, rejected)
+		}
+	}
+	if err == os.ErrExist {
+		abort(false)
+		resp.Err = "File already exist on disk"
+		ctx.JSON(409, resp)
+		return
+	}
+	if errors.Is(err, errFileTooLarge) {
+		abort(true)
+		resp.Err = err.Error()
+		ctx.JSON(413, resp)
+		return
 	}
+	if err != nil {
+		abort(true)
+		ctx.JSON(500, resp)
+		common.Log.Error(err.Error())
+		return
+	}
+	common.UploadedBytes.WithLabelValues(common.SourceLocal).Add(float64(size))
+
+	// Update info about file in postgres
+	err = pg.AddFileToNode(ctx, nodeID, file.ID)
+	if err != nil {
+		abort(true)
+		ctx.JSON(500, resp)
+		common.Log.Error(err.Error())
+		return
+	}
+	resp.Replicas++
+	if resp.Replicas < writeQuorum {
+		abort(true)
+		resp.Err = "Write quorum not reached"
+		ctx.JSON(503, resp)
+		return
+	}
+	committed, err := commitFile(file, size, checksum)
+	if err == nil && !committed.IsExist() {
+		err = fmt.Errorf("File %v disappeared before commit", uuid)
+	}
+	if err != nil {
+		abort(true)
+		ctx.JSON(500, resp)
+		common.Log.Error(err.Error())
+		return
+	}
+	file = committed
+	// other nodes still find file by periodic scan if notification is lost
+	err = pg.NotifyFileReady(ctx, uuid)
+	if err != nil {
+		common.Log.Error(err.Error())
+	}
+
+	// Send response
+	resp.ID = file.ID
+	resp.UUID = file.UUID
+	resp.Size = file.Size
+	resp.CreatedAt = file.Created_at
+	resp.SHA256 = file.SHA256
+	ctx.JSON(200, resp)
 }
@@ -0,0 +1,69 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muskelo/bronze-pheasant/app/server/pglock"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+	storagepkg "github.com/muskelo/bronze-pheasant/app/server/storage"
+)
+
+const readyCheckTimeout = 5 * time.Second
+
+var (
+	errLockNotFresh  = errors.New("Node lock isn't fresh")
+	errNodeNotActive = errors.New("Node isn't active")
+)
+
+// Liveness probe, responds while process serves requests
+func Healthz() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.String(200, "ok")
+	}
+}
+
+// Readiness probe, node is ready if postgres is reachable, lock is fresh,
+// storage is writable and node isn't draining or decommissioned
+func Readyz(nodeID int64, pg *postgres.Postgres, lock *pglock.Lock, storage *storagepkg.Storage) gin.HandlerFunc {
+	type response struct {
+		Ready  bool              `json:"ready"`
+		Checks map[string]string `json:"checks"`
+	}
+
+	return func(ctx *gin.Context) {
+		resp := response{Ready: true, Checks: map[string]string{}}
+		check := func(name string, err error) {
+			if err != nil {
+				resp.Ready = false
+				resp.Checks[name] = err.Error()
+				return
+			}
+			resp.Checks[name] = "ok"
+		}
+
+		checkCtx, cancel := context.WithTimeout(ctx, readyCheckTimeout)
+		defer cancel()
+
+		check("postgres", pg.Ping(checkCtx))
+		if lock.IsFresh() {
+			check("lock", nil)
+		} else {
+			check("lock", errLockNotFresh)
+		}
+		check("storage", storage.CheckWritable())
+		node, err := pg.GetNodeByID(checkCtx, nodeID)
+		if err == nil && (!node.IsExist() || node.State != postgres.NodeStateActive) {
+			err = errNodeNotActive
+		}
+		check("node_state", err)
+
+		if !resp.Ready {
+			ctx.JSON(503, resp)
+			return
+		}
+		ctx.JSON(200, resp)
+	}
+}
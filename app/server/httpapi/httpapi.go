@@ -1,15 +1,24 @@
 package httpapi
 
 import (
+	"crypto/tls"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/gin-gonic/gin"
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/admin"
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
 	"github.com/muskelo/bronze-pheasant/app/server/httpapi/external"
 	"github.com/muskelo/bronze-pheasant/app/server/httpapi/internal"
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/s3"
 	"github.com/muskelo/bronze-pheasant/app/server/pglock"
 	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+	"github.com/muskelo/bronze-pheasant/app/server/rebalance"
 	storagepkg "github.com/muskelo/bronze-pheasant/app/server/storage"
+	"github.com/muskelo/bronze-pheasant/lib/httpclient"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func New(
@@ -18,16 +27,77 @@ func New(
 	storage *storagepkg.Storage,
 	pg *postgres.Postgres,
 	lock *pglock.Lock,
+	rebalancer *rebalance.Rebalancer,
+	s3Credentials s3.Credentials,
+	auth bool,
+	uploadLifetime time.Duration,
+	multipartLifetime time.Duration,
 ) *http.Server {
 	router := gin.New()
-	router.Use(Logger(), gin.Recovery())
+	// probes and scrapes aren't logged
+	router.Use(Logger("/healthz", "/readyz", "/metrics"), gin.Recovery())
 
-	internalGroup := router.Group("/api/v1/internal")
-	internalGroup.GET("/files/:uuid", internal.DownloadFile(storage))
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/healthz", Healthz())
+	router.GET("/readyz", Readyz(nodeID, pg, lock, storage))
 
 	externalGroup := router.Group("/api/v1/external")
+	if auth {
+		externalGroup.Use(APIKeyAuth(pg, methodScope))
+	}
+	externalGroup.Use(ReadOnlyWhenStale(lock))
+	externalGroup.GET("/files", external.ListFiles(pg))
 	externalGroup.POST("/files/:uuid", external.UploadFile(nodeID, pg, storage))
 	externalGroup.GET("/files/:uuid", external.DownloadFile(pg, storage))
+	externalGroup.HEAD("/files/:uuid", external.HeadFile(pg))
+	externalGroup.GET("/files/:uuid/meta", external.GetFileMeta(pg, storage))
+	externalGroup.GET("/files/:uuid/presign", external.PresignFile(pg, "GET"))
+	externalGroup.POST("/files/:uuid/presign", external.PresignFile(pg, "POST"))
+	externalGroup.DELETE("/files/:uuid", external.DeleteFile(pg))
+	externalGroup.GET("/namespaces", external.GetNamespaces(pg))
+	externalGroup.POST("/namespaces/:namespace", external.CreateNamespace(pg))
+	externalGroup.DELETE("/namespaces/:namespace", external.DeleteNamespace(pg))
+	externalGroup.POST("/objects/:namespace/*key", external.UploadObject(nodeID, pg, storage))
+	externalGroup.GET("/objects/:namespace/*key", external.DownloadObject(pg, storage))
+	externalGroup.DELETE("/objects/:namespace/*key", external.DeleteObject(pg))
+	externalGroup.OPTIONS("/uploads", external.UploadOptions())
+	externalGroup.OPTIONS("/uploads/:uuid", external.UploadOptions())
+	externalGroup.POST("/uploads", external.CreateUpload(nodeID, pg, storage, uploadLifetime))
+	externalGroup.HEAD("/uploads/:uuid", external.GetUploadOffset(pg))
+	externalGroup.PATCH("/uploads/:uuid", external.PatchUpload(nodeID, pg, storage))
+	externalGroup.DELETE("/uploads/:uuid", external.TerminateUpload(nodeID, pg, storage))
+
+	adminGroup := router.Group("/api/v1/admin")
+	if auth {
+		adminGroup.Use(APIKeyAuth(pg, adminScope))
+	}
+	adminGroup.GET("/cluster", admin.GetCluster(pg))
+	adminGroup.GET("/cluster/replication-factor", admin.GetReplicationFactor(pg))
+	adminGroup.PUT("/cluster/replication-factor", admin.SetReplicationFactor(pg))
+	adminGroup.GET("/nodes/:name", admin.GetNodeState(pg))
+	adminGroup.POST("/nodes/:name/drain", admin.DrainNode(pg))
+	adminGroup.POST("/nodes/:name/activate", admin.ActivateNode(pg))
+	adminGroup.POST("/nodes/:name/decommission", admin.DecommissionNode(pg))
+	adminGroup.GET("/rebalance/plan", admin.GetRebalancePlan(rebalancer))
+	adminGroup.GET("/api-keys", admin.GetAPIKeys(pg))
+	adminGroup.POST("/api-keys", admin.CreateAPIKey(pg))
+	adminGroup.DELETE("/api-keys/:id", admin.RevokeAPIKey(pg))
+
+	// s3 api is available only if credentials are configured
+	if s3Credentials.AccessKey != "" {
+		s3Group := router.Group("/s3", s3.Auth(s3Credentials), s3.ReadOnlyWhenStale(lock))
+		s3Group.GET("", s3.ListBuckets(pg))
+		s3Group.GET("/", s3.ListBuckets(pg))
+		s3Group.PUT("/:bucket", s3.CreateBucket(pg))
+		s3Group.HEAD("/:bucket", s3.HeadBucket(pg))
+		s3Group.GET("/:bucket", s3.ListObjects(pg))
+		s3Group.DELETE("/:bucket", s3.DeleteBucket(pg))
+		s3Group.PUT("/:bucket/*key", s3.PutObject(nodeID, pg, storage))
+		s3Group.HEAD("/:bucket/*key", s3.HeadObject(pg))
+		s3Group.GET("/:bucket/*key", s3.GetObject(pg, storage))
+		s3Group.DELETE("/:bucket/*key", s3.DeleteObject(nodeID, pg, storage))
+		s3Group.POST("/:bucket/*key", s3.PostObject(nodeID, pg, storage, multipartLifetime))
+	}
 
 	return &http.Server{
 		Addr:    listen,
@@ -35,22 +105,99 @@ func New(
 	}
 }
 
+// Returns server of node to node api, clients must present certificate signed by
+// cluster ca if tlsConfig isn't nil and sign requests with secret if it isn't empty
+func NewInternal(
+	listen string,
+	nodeID int64,
+	storage *storagepkg.Storage,
+	pg *postgres.Postgres,
+	lock *pglock.Lock,
+	tlsConfig *tls.Config,
+	secret string,
+) *http.Server {
+	router := gin.New()
+	router.Use(Logger(), gin.Recovery())
+
+	internalGroup := router.Group("/api/v1/internal")
+	if secret != "" {
+		internalGroup.Use(InternalSecretAuth(secret))
+	}
+	internalGroup.Use(ReadOnlyWhenStale(lock))
+	internalGroup.GET("/files/:uuid", internal.DownloadFile(pg, storage))
+	internalGroup.PUT("/files/:uuid", internal.UploadFile(nodeID, pg, storage))
+	internalGroup.DELETE("/files/:uuid", internal.RemoveFile(nodeID, pg, storage))
+
+	return &http.Server{
+		Addr:      listen,
+		Handler:   router.Handler(),
+		TLSConfig: tlsConfig,
+	}
+}
+
 // Defautl server
 
 var (
-	httpapiListen = kingpin.Flag("httpapi.listen", "Listen address for http api").Default("0.0.0.0:3000").String()
+	httpapiListen         = kingpin.Flag("httpapi.listen", "Listen address for http api").Default("0.0.0.0:3000").String()
+	httpapiInternalListen = kingpin.Flag("httpapi.internal-listen", "Listen address for internal api, advertise addr must point to it").Default("0.0.0.0:3001").String()
+	internalTLSCA         = kingpin.Flag("httpapi.internal-tls-ca", "Cluster ca certificate, internal api uses mutual tls if set").String()
+	internalTLSCert       = kingpin.Flag("httpapi.internal-tls-cert", "Certificate of node for internal api").String()
+	internalTLSKey        = kingpin.Flag("httpapi.internal-tls-key", "Private key of node certificate").String()
+	internalSecret        = kingpin.Flag("httpapi.internal-secret", "Shared secret of cluster, requests to internal api are signed with it").String()
+	httpapiAuth           = kingpin.Flag("httpapi.auth", "Require api key on external and admin api, keys are created by ctl").Default("true").Bool()
+	uploadLifetime        = kingpin.Flag("httpapi.upload-lifetime", "Unfinished resumable uploads are removed after this time").Default("24h").Duration()
+	multipartLifetime     = kingpin.Flag("s3.multipart-lifetime", "Unfinished multipart uploads are removed with their parts after this time").Default("168h").Duration()
+	s3AccessKey           = kingpin.Flag("s3.access-key", "Access key of s3 api, s3 api is disabled if empty").String()
+	s3SecretKey           = kingpin.Flag("s3.secret-key", "Secret key of s3 api").String()
 )
 
 var (
-	Default *http.Server
+	Default         *http.Server
+	DefaultInternal *http.Server
 )
 
-func Init(nodeID int64) {
+var errInternalAuthRequired = errors.New("Internal api requires --httpapi.internal-tls-ca or --httpapi.internal-secret")
+
+func Init(nodeID int64) error {
+	if !*httpapiAuth {
+		common.Log.Warn("Api key auth is disabled, external and admin api are open to anyone")
+	}
+	if *internalTLSCA == "" && *internalSecret == "" {
+		return errInternalAuthRequired
+	}
+	if *internalSecret != "" {
+		httpclient.SetSecret(*internalSecret)
+	}
+	var tlsConfig *tls.Config
+	if *internalTLSCA != "" {
+		var err error
+		tlsConfig, err = LoadInternalTLSConfig(*internalTLSCA, *internalTLSCert, *internalTLSKey)
+		if err != nil {
+			return err
+		}
+		httpclient.SetTLSConfig(tlsConfig)
+	}
+
 	Default = New(
 		*httpapiListen,
 		nodeID,
 		storagepkg.Default,
 		postgres.Default,
 		pglock.Default,
+		rebalance.Default,
+		s3.Credentials{AccessKey: *s3AccessKey, SecretKey: *s3SecretKey},
+		*httpapiAuth,
+		*uploadLifetime,
+		*multipartLifetime,
+	)
+	DefaultInternal = NewInternal(
+		*httpapiInternalListen,
+		nodeID,
+		storagepkg.Default,
+		postgres.Default,
+		pglock.Default,
+		tlsConfig,
+		*internalSecret,
 	)
+	return nil
 }
@@ -7,10 +7,11 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
 	storagepkg "github.com/muskelo/bronze-pheasant/app/server/storage"
 )
 
-func DownloadFile(storage *storagepkg.Storage) gin.HandlerFunc {
+func DownloadFile(pg *postgres.Postgres, storage *storagepkg.Storage) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		uuid := strings.ToLower(ctx.Param("uuid"))
 		if !common.IsValidUUID(uuid) {
@@ -18,19 +19,30 @@ func DownloadFile(storage *storagepkg.Storage) gin.HandlerFunc {
 			return
 		}
 
-		file, err := storage.GetFile(uuid)
+		// Metadata is required for headers of conditional requests
+		file, err := pg.GetFileByUUID(ctx, uuid)
+		if err != nil {
+			ctx.Status(500)
+			common.Log.Error(err.Error())
+			return
+		}
+		if !file.IsExist() {
+			ctx.Status(404)
+			return
+		}
+
+		f, err := storage.GetFile(uuid)
 		if errors.Is(err, os.ErrNotExist) {
 			ctx.Status(404)
 			return
 		}
-		defer file.Close()
-		stat, err := file.Stat()
 		if err != nil {
 			ctx.Status(500)
+			common.Log.Error(err.Error())
 			return
 		}
+		defer f.Close()
 
-		ctx.DataFromReader(200, stat.Size(), "application/octet-stream", file, nil)
-		return
+		common.ServeLocalFile(ctx, file, f)
 	}
 }
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+	storagepkg "github.com/muskelo/bronze-pheasant/app/server/storage"
+)
+
+// Removes copy of file which upload was aborted by another node or which was rejected by it,
+// copies of ready files and copies registered on node aren't removed
+func RemoveFile(nodeID int64, pg *postgres.Postgres, storage *storagepkg.Storage) gin.HandlerFunc {
+	type response struct {
+		Err string `json:"err"`
+	}
+
+	return func(ctx *gin.Context) {
+		resp := response{}
+
+		uuid := strings.ToLower(ctx.Param("uuid"))
+		if !common.IsValidUUID(uuid) {
+			resp.Err = "Invalid uuid"
+			ctx.JSON(400, resp)
+			return
+		}
+
+		file, err := pg.GetFileByUUID(ctx, uuid)
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+		if file.IsExist() {
+			registered, err := isFileOnNode(ctx, pg, file, nodeID)
+			if err != nil {
+				ctx.JSON(500, resp)
+				common.Log.Error(err.Error())
+				return
+			}
+			if file.State != postgres.FileStateNew || registered {
+				resp.Err = "File exists"
+				ctx.JSON(409, resp)
+				return
+			}
+		}
+
+		err = storage.RemoveFile(uuid)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+		ctx.JSON(200, resp)
+	}
+}
+
+func isFileOnNode(ctx context.Context, pg *postgres.Postgres, file postgres.File, nodeID int64) (bool, error) {
+	nodes, err := pg.GetNodesWithinFile(ctx, file.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, node := range nodes {
+		if node.ID == nodeID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
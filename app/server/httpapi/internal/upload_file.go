@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+	storagepkg "github.com/muskelo/bronze-pheasant/app/server/storage"
+)
+
+// Receives copy of file which is being uploaded to another node
+func UploadFile(nodeID int64, pg *postgres.Postgres, storage *storagepkg.Storage) gin.HandlerFunc {
+	type response struct {
+		Err    string `json:"err"`
+		Size   int64  `json:"size"`
+		SHA256 string `json:"sha256"`
+	}
+
+	return func(ctx *gin.Context) {
+		resp := response{}
+
+		uuid := strings.ToLower(ctx.Param("uuid"))
+		if !common.IsValidUUID(uuid) {
+			resp.Err = "Invalid uuid"
+			ctx.JSON(400, resp)
+			return
+		}
+
+		// Only files which are being uploaded are accepted
+		file, err := pg.GetFileByUUIDAndState(ctx, uuid, postgres.FileStateNew)
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+		if !file.IsExist() {
+			resp.Err = "File not found"
+			ctx.JSON(404, resp)
+			return
+		}
+
+		size, checksum, err := storage.WriteFile(uuid, ctx.Request.Body)
+		if err == os.ErrExist {
+			resp.Err = "File already exist on disk"
+			ctx.JSON(409, resp)
+			return
+		}
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+		err = pg.AddFileToNode(ctx, nodeID, file.ID)
+		if err != nil {
+			ctx.JSON(500, resp)
+			common.Log.Error(err.Error())
+			return
+		}
+
+		resp.Size = size
+		resp.SHA256 = checksum
+		ctx.JSON(200, resp)
+	}
+}
@@ -0,0 +1,158 @@
+package httpapi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muskelo/bronze-pheasant/lib/httpclient"
+)
+
+// Max difference between timestamp of signed request and time of node
+const maxInternalSkew = 5 * time.Minute
+
+var (
+	errInternalUnsigned         = errors.New("Request isn't signed")
+	errInternalInvalidSignature = errors.New("Invalid signature")
+	errInternalExpired          = errors.New("Request timestamp is too far from node time")
+	errInternalReplayed         = errors.New("Request was already received")
+	errInternalInvalidBody      = errors.New("Invalid signature of body")
+)
+
+// Nonces of accepted requests, a nonce is kept until request with it would expire anyway
+type nonceCache struct {
+	mutex  sync.Mutex
+	nonces map[string]time.Time
+	pruned time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{nonces: map[string]time.Time{}}
+}
+
+// Returns false if nonce was already added
+func (c *nonceCache) add(nonce string, now time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if now.Sub(c.pruned) > maxInternalSkew {
+		for n, added := range c.nonces {
+			if now.Sub(added) > 2*maxInternalSkew {
+				delete(c.nonces, n)
+			}
+		}
+		c.pruned = now
+	}
+	if _, ok := c.nonces[nonce]; ok {
+		return false
+	}
+	c.nonces[nonce] = now
+	return true
+}
+
+// Verifies body of signed request while it's read, reading fails at the end of body if
+// trailers don't match it. Body of request signed as empty must be empty.
+type verifyingBody struct {
+	r         *http.Request
+	body      io.ReadCloser
+	hash      hash.Hash
+	secret    string
+	signature string
+	bodyMode  string
+}
+
+func (b *verifyingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if b.bodyMode == httpclient.BodyEmpty {
+		if n > 0 {
+			return 0, errInternalInvalidBody
+		}
+		return n, err
+	}
+	b.hash.Write(p[:n])
+	if err == io.EOF {
+		// trailers are read together with the end of body
+		checksum := hex.EncodeToString(b.hash.Sum(nil))
+		signature, decodeErr := hex.DecodeString(b.r.Trailer.Get(httpclient.ContentSignatureTrailer))
+		expected, _ := hex.DecodeString(httpclient.BodySignature(b.secret, b.signature, checksum))
+		if decodeErr != nil || b.r.Trailer.Get(httpclient.ContentSHA256Trailer) != checksum || !hmac.Equal(signature, expected) {
+			return n, errInternalInvalidBody
+		}
+	}
+	return n, err
+}
+
+func (b *verifyingBody) Close() error {
+	return b.body.Close()
+}
+
+// Verifies request signed by httpclient with shared secret of cluster, body of request is
+// verified when it's read
+func verifyInternalSignature(r *http.Request, secret string, now time.Time, nonces *nonceCache) error {
+	value := r.Header.Get(httpclient.TimestampHeader)
+	if value == "" {
+		return errInternalUnsigned
+	}
+	timestamp, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return errInternalInvalidSignature
+	}
+	nonce := r.Header.Get(httpclient.NonceHeader)
+	if nonce == "" {
+		return errInternalInvalidSignature
+	}
+	signature, err := hex.DecodeString(r.Header.Get(httpclient.SignatureHeader))
+	if err != nil {
+		return errInternalInvalidSignature
+	}
+	bodyMode := httpclient.BodyEmpty
+	if _, ok := r.Trailer[httpclient.ContentSHA256Trailer]; ok {
+		bodyMode = httpclient.BodyTrailer
+	}
+	expectedSignature := httpclient.Signature(secret, r.Method, r.Host, r.URL.RequestURI(), timestamp, nonce, bodyMode)
+	expected, _ := hex.DecodeString(expectedSignature)
+	if !hmac.Equal(signature, expected) {
+		return errInternalInvalidSignature
+	}
+	if skew := now.Sub(time.Unix(timestamp, 0)); skew > maxInternalSkew || skew < -maxInternalSkew {
+		return errInternalExpired
+	}
+	if !nonces.add(nonce, now) {
+		return errInternalReplayed
+	}
+	if r.Body != nil {
+		r.Body = &verifyingBody{
+			r:         r,
+			body:      r.Body,
+			hash:      sha256.New(),
+			secret:    secret,
+			signature: expectedSignature,
+			bodyMode:  bodyMode,
+		}
+	}
+	return nil
+}
+
+// Rejects requests to internal api which aren't signed by shared secret of cluster or were
+// already received
+func InternalSecretAuth(secret string) gin.HandlerFunc {
+	type response struct {
+		Err string `json:"err"`
+	}
+
+	nonces := newNonceCache()
+	return func(ctx *gin.Context) {
+		err := verifyInternalSignature(ctx.Request, secret, time.Now(), nonces)
+		if err != nil {
+			ctx.AbortWithStatusJSON(401, response{Err: err.Error()})
+		}
+	}
+}
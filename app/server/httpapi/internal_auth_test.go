@@ -0,0 +1,165 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muskelo/bronze-pheasant/lib/httpclient"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyInternalSignature(t *testing.T) {
+	const secret = "cluster secret"
+	now := time.Unix(1700000000, 0)
+	host := "node1:3001"
+	path := "/api/v1/internal/files/3adc6469-2691-4ba4-8245-94b0c30b15ef"
+
+	type request struct {
+		secret    string
+		method    string
+		uri       string
+		timestamp int64
+		nonce     string
+		bodyMode  string
+	}
+	valid := func() request {
+		return request{secret, "GET", path, now.Unix(), "nonce", httpclient.BodyEmpty}
+	}
+	newRequest := func(signed request, method string, uri string, body io.Reader) *http.Request {
+		r := httptest.NewRequest(method, "http://"+host+uri, body)
+		signature := httpclient.Signature(signed.secret, signed.method, host, signed.uri, signed.timestamp, signed.nonce, signed.bodyMode)
+		r.Header.Set(httpclient.TimestampHeader, strconv.FormatInt(signed.timestamp, 10))
+		r.Header.Set(httpclient.NonceHeader, signed.nonce)
+		r.Header.Set(httpclient.SignatureHeader, signature)
+		return r
+	}
+	verify := func(signed request) error {
+		return verifyInternalSignature(newRequest(signed, "GET", path, nil), secret, now, newNonceCache())
+	}
+
+	t.Log("Test verifyInternalSignature function")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tValid signature is accepted", testID)
+		{
+			require.NoError(t, verify(valid()))
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tUnsigned request is rejected", testID)
+		{
+			r := httptest.NewRequest("GET", "http://"+host+path, nil)
+			require.ErrorIs(t, verifyInternalSignature(r, secret, now, newNonceCache()), errInternalUnsigned)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tSignature with another secret is rejected", testID)
+		{
+			signed := valid()
+			signed.secret = "another secret"
+			require.ErrorIs(t, verify(signed), errInternalInvalidSignature)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tSignature of another method is rejected", testID)
+		{
+			signed := valid()
+			signed.method = "PUT"
+			require.ErrorIs(t, verify(signed), errInternalInvalidSignature)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tSignature of another query is rejected", testID)
+		{
+			r := newRequest(valid(), "GET", path+"?force=1", nil)
+			require.ErrorIs(t, verifyInternalSignature(r, secret, now, newNonceCache()), errInternalInvalidSignature)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tOld signature is rejected", testID)
+		{
+			signed := valid()
+			signed.timestamp = now.Add(-time.Hour).Unix()
+			require.ErrorIs(t, verify(signed), errInternalExpired)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tReplayed request is rejected", testID)
+		{
+			nonces := newNonceCache()
+			signed := valid()
+			signed.method = "DELETE"
+			require.NoError(t, verifyInternalSignature(newRequest(signed, "DELETE", path, nil), secret, now, nonces))
+			require.ErrorIs(t, verifyInternalSignature(newRequest(signed, "DELETE", path, nil), secret, now, nonces), errInternalReplayed)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tBody of request signed as empty is rejected", testID)
+		{
+			signed := valid()
+			signed.method = "PUT"
+			r := newRequest(signed, "PUT", path, strings.NewReader("hello"))
+			require.NoError(t, verifyInternalSignature(r, secret, now, newNonceCache()))
+			_, err := io.ReadAll(r.Body)
+			require.ErrorIs(t, err, errInternalInvalidBody)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tSwapped body is rejected", testID)
+		{
+			signed := valid()
+			signed.method = "PUT"
+			signed.bodyMode = httpclient.BodyTrailer
+			r := newRequest(signed, "PUT", path, strings.NewReader("swapped"))
+			signature := httpclient.Signature(secret, "PUT", host, path, now.Unix(), "nonce", httpclient.BodyTrailer)
+			checksum := sha256.Sum256([]byte("hello"))
+			r.Trailer = http.Header{
+				httpclient.ContentSHA256Trailer:    {hex.EncodeToString(checksum[:])},
+				httpclient.ContentSignatureTrailer: {httpclient.BodySignature(secret, signature, hex.EncodeToString(checksum[:]))},
+			}
+			require.NoError(t, verifyInternalSignature(r, secret, now, newNonceCache()))
+			_, err := io.ReadAll(r.Body)
+			require.ErrorIs(t, err, errInternalInvalidBody)
+		}
+	}
+}
+
+func TestInternalSecretAuth(t *testing.T) {
+	const secret = "cluster secret"
+
+	router := gin.New()
+	router.Use(InternalSecretAuth(secret))
+	router.PUT("/api/v1/internal/files/:uuid", func(ctx *gin.Context) {
+		body, err := io.ReadAll(ctx.Request.Body)
+		if err != nil {
+			ctx.String(400, err.Error())
+			return
+		}
+		ctx.String(200, string(body))
+	})
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	httpclient.SetSecret(secret)
+	t.Cleanup(func() { httpclient.SetSecret("") })
+
+	t.Logf("\tTest %d:\tStreamed body is signed by trailers", 0)
+	{
+		resp, err := httpclient.PutV1InternalFiles(context.Background(), server.URL, "3adc6469-2691-4ba4-8245-94b0c30b15ef", strings.NewReader("hello"))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, 200, resp.StatusCode, string(body))
+		require.Equal(t, "hello", string(body))
+	}
+}
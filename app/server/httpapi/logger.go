@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -37,6 +38,14 @@ func Logger(notLogged ...string) gin.HandlerFunc {
 			dataLength = 0
 		}
 
+		route := endpoint
+		if route == "" {
+			// keeps cardinality low for requests to unknown paths
+			route = "unmatched"
+		}
+		httpRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(statusCode)).Inc()
+		httpRequestDuration.WithLabelValues(c.Request.Method, route).Observe(stop.Seconds())
+
 		if _, ok := skip[path]; ok {
 			return
 		}
@@ -0,0 +1,18 @@
+package httpapi
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bronze_pheasant_http_requests_total",
+		Help: "Number of http requests by route and status code",
+	}, []string{"method", "route", "status"})
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "bronze_pheasant_http_request_duration_seconds",
+		Help:    "Time to process http request by route",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"method", "route"})
+)
@@ -0,0 +1,27 @@
+package httpapi
+
+import (
+	"github.com/gin-gonic/gin"
+	locklib "github.com/muskelo/bronze-pheasant/lib/lock"
+)
+
+func isWriteMethod(method string) bool {
+	switch method {
+	case "POST", "PUT", "PATCH", "DELETE":
+		return true
+	}
+	return false
+}
+
+// Rejects writes while node lock isn't fresh, node keeps serving reads until it gets lock back
+func ReadOnlyWhenStale(lock locklib.Lock) gin.HandlerFunc {
+	type response struct {
+		Err string `json:"err"`
+	}
+
+	return func(ctx *gin.Context) {
+		if isWriteMethod(ctx.Request.Method) && !lock.IsFresh() {
+			ctx.AbortWithStatusJSON(503, response{Err: "Node is read-only until it takes lock again"})
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AWS signature version 4, both authorization header and presigned urls are supported
+
+const (
+	signV4Algorithm    = "AWS4-HMAC-SHA256"
+	amzDateFormat      = "20060102T150405Z"
+	unsignedPayload    = "UNSIGNED-PAYLOAD"
+	emptySHA256        = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	maxClockSkew       = 15 * time.Minute
+	maxPresignedExpiry = 7 * 24 * time.Hour
+	signingContextKey  = "s3.signing"
+)
+
+// Everything needed to verify signatures of chunks after request signature is checked
+type signingContext struct {
+	key       []byte
+	amzDate   string
+	scope     string
+	signature string
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Signature of chunk of aws-chunked payload, previous is signature of previous chunk or request
+func (sc *signingContext) chunkSignature(previous string, data []byte) string {
+	stringToSign := strings.Join([]string{
+		signV4Algorithm + "-PAYLOAD",
+		sc.amzDate,
+		sc.scope,
+		previous,
+		emptySHA256,
+		sha256Hex(data),
+	}, "\n")
+	return hex.EncodeToString(hmacSHA256(sc.key, stringToSign))
+}
+
+// Percent encodes everything except unreserved characters as required by aws
+func uriEncode(s string, encodeSlash bool) string {
+	const hexChars = "0123456789ABCDEF"
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexChars[c>>4])
+			b.WriteByte(hexChars[c&15])
+		}
+	}
+	return b.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	pairs := []string{}
+	for key, values := range query {
+		if key == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func canonicalHeaderValue(r *http.Request, name string) string {
+	if name == "host" {
+		return r.Host
+	}
+	values := r.Header.Values(name)
+	if len(values) == 0 && name == "content-length" && r.ContentLength >= 0 {
+		return strconv.FormatInt(r.ContentLength, 10)
+	}
+	trimmed := make([]string, len(values))
+	for i, value := range values {
+		trimmed[i] = strings.Join(strings.Fields(value), " ")
+	}
+	return strings.Join(trimmed, ",")
+}
+
+func canonicalRequest(r *http.Request, query url.Values, signedHeaders []string, payloadHash string) string {
+	path := r.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	headers := strings.Builder{}
+	for _, name := range signedHeaders {
+		headers.WriteString(name + ":" + canonicalHeaderValue(r, name) + "\n")
+	}
+	return strings.Join([]string{
+		r.Method,
+		uriEncode(path, false),
+		canonicalQuery(query),
+		headers.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+// Signed parameters of request
+type signature struct {
+	accessKey     string
+	date          string
+	region        string
+	service       string
+	amzDate       string
+	signedHeaders []string
+	signature     string
+	payloadHash   string
+	// zero for requests with authorization header
+	expires time.Duration
+}
+
+// Parses credential scope <access key>/<date>/<region>/<service>/aws4_request
+func (sig *signature) parseCredential(credential string) error {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" {
+		return errMalformedAuth
+	}
+	sig.accessKey, sig.date, sig.region, sig.service = parts[0], parts[1], parts[2], parts[3]
+	return nil
+}
+
+func (sig *signature) scope() string {
+	return strings.Join([]string{sig.date, sig.region, sig.service, "aws4_request"}, "/")
+}
+
+func parseAuthorizationHeader(r *http.Request) (sig signature, err error) {
+	algorithm, params, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if algorithm != signV4Algorithm {
+		err = errAccessDenied
+		return
+	}
+	for _, param := range strings.Split(params, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch name {
+		case "Credential":
+			err = sig.parseCredential(value)
+			if err != nil {
+				return
+			}
+		case "SignedHeaders":
+			sig.signedHeaders = strings.Split(value, ";")
+		case "Signature":
+			sig.signature = value
+		}
+	}
+	sig.amzDate = r.Header.Get("X-Amz-Date")
+	sig.payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+	if sig.accessKey == "" || sig.signature == "" || len(sig.signedHeaders) == 0 || sig.payloadHash == "" {
+		err = errMalformedAuth
+	}
+	return
+}
+
+func parsePresignedQuery(query url.Values) (sig signature, err error) {
+	if query.Get("X-Amz-Algorithm") != signV4Algorithm {
+		err = errAccessDenied
+		return
+	}
+	err = sig.parseCredential(query.Get("X-Amz-Credential"))
+	if err != nil {
+		return
+	}
+	sig.amzDate = query.Get("X-Amz-Date")
+	sig.signedHeaders = strings.Split(query.Get("X-Amz-SignedHeaders"), ";")
+	sig.signature = query.Get("X-Amz-Signature")
+	sig.payloadHash = unsignedPayload
+	expires, err := strconv.ParseInt(query.Get("X-Amz-Expires"), 10, 64)
+	if err != nil || expires <= 0 || time.Duration(expires)*time.Second > maxPresignedExpiry {
+		err = errMalformedAuth
+		return
+	}
+	sig.expires = time.Duration(expires) * time.Second
+	return
+}
+
+// Checks signature of request, returns context for verifying signatures of payload chunks
+func verifyRequest(r *http.Request, credentials Credentials, now time.Time) (*signingContext, error) {
+	query := r.URL.Query()
+	var sig signature
+	var err error
+	if query.Has("X-Amz-Signature") {
+		sig, err = parsePresignedQuery(query)
+	} else {
+		sig, err = parseAuthorizationHeader(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if sig.accessKey != credentials.AccessKey {
+		return nil, errInvalidAccessKeyID
+	}
+	if sig.service != "s3" || !slices.Contains(sig.signedHeaders, "host") {
+		return nil, errMalformedAuth
+	}
+	signedAt, err := time.Parse(amzDateFormat, sig.amzDate)
+	if err != nil || signedAt.Format("20060102") != sig.date {
+		return nil, errMalformedAuth
+	}
+	if sig.expires == 0 && (now.Sub(signedAt) > maxClockSkew || signedAt.Sub(now) > maxClockSkew) {
+		return nil, errRequestTimeTooSkewed
+	}
+	if sig.expires != 0 && (now.After(signedAt.Add(sig.expires)) || signedAt.Sub(now) > maxClockSkew) {
+		return nil, errExpiredToken
+	}
+
+	key := []byte("AWS4" + credentials.SecretKey)
+	for _, part := range []string{sig.date, sig.region, sig.service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	stringToSign := strings.Join([]string{
+		signV4Algorithm,
+		sig.amzDate,
+		sig.scope(),
+		sha256Hex([]byte(canonicalRequest(r, query, sig.signedHeaders, sig.payloadHash))),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(sig.signature)) {
+		return nil, errSignatureDoesNotMatch
+	}
+	return &signingContext{key: key, amzDate: sig.amzDate, scope: sig.scope(), signature: sig.signature}, nil
+}
+
+// Rejects requests without valid signature of credentials
+func Auth(credentials Credentials) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		sc, err := verifyRequest(ctx.Request, credentials, time.Now())
+		if err != nil {
+			writeError(ctx, err)
+			ctx.Abort()
+			return
+		}
+		ctx.Set(signingContextKey, sc)
+	}
+}
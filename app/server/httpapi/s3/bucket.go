@@ -0,0 +1,235 @@
+package s3
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+)
+
+func ListBuckets(pg *postgres.Postgres) gin.HandlerFunc {
+	type bucket struct {
+		Name         string `xml:"Name"`
+		CreationDate string `xml:"CreationDate"`
+	}
+	type response struct {
+		XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Owner   struct {
+			ID          string `xml:"ID"`
+			DisplayName string `xml:"DisplayName"`
+		} `xml:"Owner"`
+		Buckets []bucket `xml:"Buckets>Bucket"`
+	}
+
+	return func(ctx *gin.Context) {
+		namespaces, err := pg.GetNamespaces(ctx)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		resp := response{Xmlns: xmlns}
+		for _, namespace := range namespaces {
+			resp.Buckets = append(resp.Buckets, bucket{
+				Name:         namespace.Name,
+				CreationDate: formatTime(namespace.Created_at),
+			})
+		}
+		ctx.XML(200, resp)
+	}
+}
+
+func CreateBucket(pg *postgres.Postgres) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		name := ctx.Param("bucket")
+		if !common.IsValidNamespace(name) {
+			writeError(ctx, errInvalidBucketName)
+			return
+		}
+		_, err := pg.CreateNamespace(ctx, name)
+		if errors.Is(err, postgres.ErrNamespaceExist) {
+			writeError(ctx, errBucketAlreadyOwned)
+			return
+		}
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		ctx.Header("Location", "/"+name)
+		ctx.Status(200)
+	}
+}
+
+func HeadBucket(pg *postgres.Postgres) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		_, err := getBucket(ctx, pg)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		ctx.Status(200)
+	}
+}
+
+func DeleteBucket(pg *postgres.Postgres) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		namespace, err := getBucket(ctx, pg)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		err = pg.DeleteNamespace(ctx, namespace.ID)
+		if errors.Is(err, postgres.ErrNamespaceNotEmpty) {
+			writeError(ctx, errBucketNotEmpty)
+			return
+		}
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		ctx.Status(204)
+	}
+}
+
+// Lists objects of bucket, both ListObjects and ListObjectsV2 are supported
+func ListObjects(pg *postgres.Postgres) gin.HandlerFunc {
+	type object struct {
+		Key          string `xml:"Key"`
+		LastModified string `xml:"LastModified"`
+		ETag         string `xml:"ETag"`
+		Size         int64  `xml:"Size"`
+		StorageClass string `xml:"StorageClass"`
+	}
+	type commonPrefix struct {
+		Prefix string `xml:"Prefix"`
+	}
+	type response struct {
+		XMLName               xml.Name       `xml:"ListBucketResult"`
+		Xmlns                 string         `xml:"xmlns,attr"`
+		Name                  string         `xml:"Name"`
+		Prefix                string         `xml:"Prefix"`
+		Delimiter             string         `xml:"Delimiter,omitempty"`
+		MaxKeys               int64          `xml:"MaxKeys"`
+		EncodingType          string         `xml:"EncodingType,omitempty"`
+		IsTruncated           bool           `xml:"IsTruncated"`
+		Marker                *string        `xml:"Marker"`
+		NextMarker            string         `xml:"NextMarker,omitempty"`
+		KeyCount              *int64         `xml:"KeyCount"`
+		ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+		NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+		StartAfter            string         `xml:"StartAfter,omitempty"`
+		Contents              []object       `xml:"Contents"`
+		CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+	}
+
+	return func(ctx *gin.Context) {
+		namespace, err := getBucket(ctx, pg)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+
+		v2 := ctx.Query("list-type") == "2"
+		prefix := ctx.Query("prefix")
+		delimiter := ctx.Query("delimiter")
+		maxKeys := int64(1000)
+		if value := ctx.Query("max-keys"); value != "" {
+			maxKeys, err = strconv.ParseInt(value, 10, 64)
+			if err != nil || maxKeys < 0 {
+				writeError(ctx, errInvalidArgument)
+				return
+			}
+			maxKeys = min(maxKeys, 1000)
+		}
+		encode := func(s string) string { return s }
+		if ctx.Query("encoding-type") == "url" {
+			encode = func(s string) string { return uriEncode(s, false) }
+		}
+
+		// listing continues with keys greater than after
+		after := ""
+		if v2 {
+			after = ctx.Query("start-after")
+			if token := ctx.Query("continuation-token"); token != "" {
+				decoded, err := base64.RawURLEncoding.DecodeString(token)
+				if err != nil {
+					writeError(ctx, errInvalidArgument)
+					return
+				}
+				after = string(decoded)
+			}
+		} else {
+			after = ctx.Query("marker")
+			// marker can be a common prefix returned by previous page
+			if delimiter != "" && strings.HasSuffix(after, delimiter) {
+				after += string(utf8.MaxRune)
+			}
+		}
+
+		resp := response{
+			Xmlns:        xmlns,
+			Name:         namespace.Name,
+			Prefix:       encode(prefix),
+			Delimiter:    encode(delimiter),
+			MaxKeys:      maxKeys,
+			EncodingType: ctx.Query("encoding-type"),
+		}
+		count, last := int64(0), ""
+		for done := false; !done; {
+			files, err := pg.ListObjects(ctx, namespace.ID, prefix, after, maxKeys+1)
+			if err != nil {
+				writeError(ctx, err)
+				return
+			}
+			done = int64(len(files)) <= maxKeys
+			for _, file := range files {
+				if count == maxKeys {
+					resp.IsTruncated = true
+					done = true
+					break
+				}
+				count++
+				if delimiter != "" {
+					if i := strings.Index(file.Key[len(prefix):], delimiter); i >= 0 {
+						// keys with the same common prefix are skipped by next query
+						cp := file.Key[:len(prefix)+i+len(delimiter)]
+						resp.CommonPrefixes = append(resp.CommonPrefixes, commonPrefix{Prefix: encode(cp)})
+						last, after = cp, cp+string(utf8.MaxRune)
+						done = false
+						break
+					}
+				}
+				resp.Contents = append(resp.Contents, object{
+					Key:          encode(file.Key),
+					LastModified: formatTime(file.Created_at),
+					ETag:         common.ETag(file),
+					Size:         file.Size,
+					StorageClass: "STANDARD",
+				})
+				last, after = file.Key, file.Key
+			}
+		}
+
+		if v2 {
+			resp.KeyCount = &count
+			resp.ContinuationToken = ctx.Query("continuation-token")
+			resp.StartAfter = encode(ctx.Query("start-after"))
+			if resp.IsTruncated {
+				resp.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(after))
+			}
+		} else {
+			marker := encode(ctx.Query("marker"))
+			resp.Marker = &marker
+			if resp.IsTruncated && delimiter != "" {
+				resp.NextMarker = encode(last)
+			}
+		}
+		ctx.XML(200, resp)
+	}
+}
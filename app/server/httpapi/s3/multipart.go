@@ -0,0 +1,287 @@
+package s3
+
+import (
+	"encoding/xml"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	uuidp "github.com/google/uuid"
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+	storagepkg "github.com/muskelo/bronze-pheasant/app/server/storage"
+)
+
+// Parts of multipart upload are kept in part files of node which started upload, they aren't
+// replicated or proxied. All requests of upload must be sent to the same node, so load
+// balancer in front of cluster must route them with sticky sessions. Requests sent to
+// another node fail with errUploadOnAnotherNode.
+
+const maxPartNumber = 10000
+
+func partETag(part postgres.MultipartPart) string {
+	return `"` + part.SHA256 + `"`
+}
+
+// Returns upload from query which belongs to object from path
+func getMultipartUpload(ctx *gin.Context, pg *postgres.Postgres) (upload postgres.MultipartUpload, err error) {
+	namespace, key, err := getBucketAndKey(ctx, pg)
+	if err != nil {
+		return
+	}
+	uuid := ctx.Query("uploadId")
+	if !common.IsValidUUID(uuid) {
+		err = errNoSuchUpload
+		return
+	}
+	upload, err = pg.GetMultipartUpload(ctx, uuid)
+	if err != nil {
+		return
+	}
+	// expired upload is removed by storage gc of its node
+	if !upload.IsExist() || upload.IsExpired() || upload.NamespaceID != namespace.ID || upload.Key != key {
+		err = errNoSuchUpload
+	}
+	return
+}
+
+// Creates upload which is removed with its parts if it isn't completed during lifetime
+func CreateMultipartUpload(nodeID int64, pg *postgres.Postgres, lifetime time.Duration) gin.HandlerFunc {
+	type response struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		UploadID string   `xml:"UploadId"`
+	}
+
+	return func(ctx *gin.Context) {
+		namespace, key, err := getBucketAndKey(ctx, pg)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		metadata, err := requestMetadata(ctx)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		upload, err := pg.CreateMultipartUpload(ctx, uuidp.NewString(), namespace.ID, key, nodeID, metadata, lifetime)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		ctx.XML(200, response{
+			Xmlns:    xmlns,
+			Bucket:   namespace.Name,
+			Key:      key,
+			UploadID: upload.UUID,
+		})
+	}
+}
+
+func UploadPart(nodeID int64, pg *postgres.Postgres, storage *storagepkg.Storage) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		number, err := strconv.ParseInt(ctx.Query("partNumber"), 10, 64)
+		if err != nil || number < 1 || number > maxPartNumber {
+			writeError(ctx, errInvalidArgument)
+			return
+		}
+		upload, err := getMultipartUpload(ctx, pg)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		if upload.NodeID != nodeID {
+			writeError(ctx, errUploadOnAnotherNode)
+			return
+		}
+		src, err := getPayload(ctx)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+
+		size, checksum, err := storage.WritePartfile(upload.UUID, number, src)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		err = src.verify(size, checksum)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		common.UploadedBytes.WithLabelValues(common.SourceLocal).Add(float64(size))
+		part := postgres.MultipartPart{Number: number, Size: size, SHA256: checksum}
+		err = pg.PutMultipartPart(ctx, upload.ID, part)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		ctx.Header("ETag", partETag(part))
+		ctx.Status(200)
+	}
+}
+
+func CompleteMultipartUpload(nodeID int64, pg *postgres.Postgres, storage *storagepkg.Storage) gin.HandlerFunc {
+	type request struct {
+		Parts []struct {
+			PartNumber int64  `xml:"PartNumber"`
+			ETag       string `xml:"ETag"`
+		} `xml:"Part"`
+	}
+	type response struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Bucket  string   `xml:"Bucket"`
+		Key     string   `xml:"Key"`
+		ETag    string   `xml:"ETag"`
+	}
+
+	return func(ctx *gin.Context) {
+		upload, err := getMultipartUpload(ctx, pg)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		if upload.NodeID != nodeID {
+			writeError(ctx, errUploadOnAnotherNode)
+			return
+		}
+		req := request{}
+		err = xml.NewDecoder(ctx.Request.Body).Decode(&req)
+		if err != nil || len(req.Parts) == 0 {
+			writeError(ctx, errMalformedXML)
+			return
+		}
+
+		// check that requested parts are uploaded and open them in order
+		uploaded, err := pg.GetMultipartParts(ctx, upload.ID)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		parts := map[int64]postgres.MultipartPart{}
+		for _, part := range uploaded {
+			parts[part.Number] = part
+		}
+		readers := []io.Reader{}
+		for i, requested := range req.Parts {
+			if i > 0 && requested.PartNumber <= req.Parts[i-1].PartNumber {
+				writeError(ctx, errInvalidPartOrder)
+				return
+			}
+			part, ok := parts[requested.PartNumber]
+			if !ok || strings.Trim(requested.ETag, `"`) != part.SHA256 {
+				writeError(ctx, errInvalidPart)
+				return
+			}
+			f, err := storage.GetPartfile(upload.UUID, part.Number)
+			if os.IsNotExist(err) {
+				writeError(ctx, errInvalidPart)
+				return
+			}
+			if err != nil {
+				writeError(ctx, err)
+				return
+			}
+			defer f.Close()
+			readers = append(readers, f)
+		}
+
+		namespace, err := getBucket(ctx, pg)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		file, err := writeObject(ctx, nodeID, pg, storage, namespace.ID, upload.Key, upload.Metadata, payload{Reader: io.MultiReader(readers...), size: -1})
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		err = pg.DeleteMultipartUpload(ctx, upload.ID)
+		if err != nil {
+			common.Log.Error(err.Error())
+		}
+		err = storage.RemovePartfiles(upload.UUID)
+		if err != nil {
+			common.Log.Error(err.Error())
+		}
+
+		ctx.XML(200, response{
+			Xmlns:  xmlns,
+			Bucket: namespace.Name,
+			Key:    upload.Key,
+			ETag:   common.ETag(file),
+		})
+	}
+}
+
+func AbortMultipartUpload(nodeID int64, pg *postgres.Postgres, storage *storagepkg.Storage) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		upload, err := getMultipartUpload(ctx, pg)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		// parts are removed only on node which stores them
+		if upload.NodeID != nodeID {
+			writeError(ctx, errUploadOnAnotherNode)
+			return
+		}
+		err = pg.DeleteMultipartUpload(ctx, upload.ID)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		err = storage.RemovePartfiles(upload.UUID)
+		if err != nil {
+			common.Log.Error(err.Error())
+		}
+		ctx.Status(204)
+	}
+}
+
+func ListParts(pg *postgres.Postgres) gin.HandlerFunc {
+	type part struct {
+		PartNumber int64  `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+		Size       int64  `xml:"Size"`
+	}
+	type response struct {
+		XMLName     xml.Name `xml:"ListPartsResult"`
+		Xmlns       string   `xml:"xmlns,attr"`
+		Bucket      string   `xml:"Bucket"`
+		Key         string   `xml:"Key"`
+		UploadID    string   `xml:"UploadId"`
+		IsTruncated bool     `xml:"IsTruncated"`
+		Parts       []part   `xml:"Part"`
+	}
+
+	return func(ctx *gin.Context) {
+		upload, err := getMultipartUpload(ctx, pg)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		parts, err := pg.GetMultipartParts(ctx, upload.ID)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		resp := response{
+			Xmlns:    xmlns,
+			Bucket:   ctx.Param("bucket"),
+			Key:      upload.Key,
+			UploadID: upload.UUID,
+		}
+		for _, p := range parts {
+			resp.Parts = append(resp.Parts, part{PartNumber: p.Number, ETag: partETag(p), Size: p.Size})
+		}
+		ctx.XML(200, resp)
+	}
+}
@@ -0,0 +1,229 @@
+package s3
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	uuidp "github.com/google/uuid"
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+	storagepkg "github.com/muskelo/bronze-pheasant/app/server/storage"
+)
+
+// Saves object from src on node and makes it visible under key, previous version is deleted
+func writeObject(
+	ctx *gin.Context,
+	nodeID int64,
+	pg *postgres.Postgres,
+	storage *storagepkg.Storage,
+	namespaceID int64,
+	key string,
+	metadata postgres.FileMetadata,
+	src payload,
+) (file postgres.File, err error) {
+	uuid := uuidp.NewString()
+	file, err = pg.CreateObject(ctx, uuid, namespaceID, key, 0, metadata)
+	if err != nil {
+		return
+	}
+	// failed write leaves nothing behind
+	fileID := file.ID
+	defer func() {
+		if err == nil {
+			return
+		}
+		abortErr := pg.AbortFile(ctx, fileID)
+		if abortErr != nil {
+			common.Log.Errorf("Failed abort upload of %v: %v", uuid, abortErr)
+			return
+		}
+		storage.RemoveFile(uuid)
+	}()
+	size, checksum, err := storage.WriteFile(uuid, src)
+	if err != nil {
+		return
+	}
+	err = src.verify(size, checksum)
+	if err != nil {
+		return
+	}
+	err = pg.AddFileToNode(ctx, nodeID, file.ID)
+	if err != nil {
+		return
+	}
+	file, err = pg.CommitObject(ctx, file.ID, size, checksum)
+	if err != nil {
+		return
+	}
+	if !file.IsExist() {
+		err = fmt.Errorf("Object %v disappeared before commit", uuid)
+		return
+	}
+	// other nodes still find file by periodic scan if notification is lost
+	notifyErr := pg.NotifyFileReady(ctx, uuid)
+	if notifyErr != nil {
+		common.Log.Error(notifyErr.Error())
+	}
+	return
+}
+
+// Handles PutObject and UploadPart
+func PutObject(nodeID int64, pg *postgres.Postgres, storage *storagepkg.Storage) gin.HandlerFunc {
+	createBucket := CreateBucket(pg)
+	uploadPart := UploadPart(nodeID, pg, storage)
+
+	return func(ctx *gin.Context) {
+		if objectKey(ctx) == "" {
+			createBucket(ctx)
+			return
+		}
+		if ctx.Query("uploadId") != "" {
+			uploadPart(ctx)
+			return
+		}
+		if ctx.GetHeader("X-Amz-Copy-Source") != "" {
+			writeError(ctx, errNotImplemented)
+			return
+		}
+
+		namespace, key, err := getBucketAndKey(ctx, pg)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		metadata, err := requestMetadata(ctx)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		src, err := getPayload(ctx)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		file, err := writeObject(ctx, nodeID, pg, storage, namespace.ID, key, metadata, src)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		common.UploadedBytes.WithLabelValues(common.SourceLocal).Add(float64(file.Size))
+		ctx.Header("ETag", common.ETag(file))
+		ctx.Status(200)
+	}
+}
+
+// Handles GetObject and ListParts
+func GetObject(pg *postgres.Postgres, storage *storagepkg.Storage) gin.HandlerFunc {
+	listObjects := ListObjects(pg)
+	listParts := ListParts(pg)
+
+	return func(ctx *gin.Context) {
+		if objectKey(ctx) == "" {
+			listObjects(ctx)
+			return
+		}
+		if ctx.Query("uploadId") != "" {
+			listParts(ctx)
+			return
+		}
+
+		namespace, key, err := getBucketAndKey(ctx, pg)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		file, err := pg.GetObject(ctx, namespace.ID, key)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		if !file.IsExist() {
+			writeError(ctx, errNoSuchKey)
+			return
+		}
+		common.SetTagHeaders(ctx, file.Tags, metaHeaderPrefix)
+		common.ServeFile(ctx, pg, storage, file)
+	}
+}
+
+func HeadObject(pg *postgres.Postgres) gin.HandlerFunc {
+	headBucket := HeadBucket(pg)
+
+	return func(ctx *gin.Context) {
+		if objectKey(ctx) == "" {
+			headBucket(ctx)
+			return
+		}
+
+		namespace, key, err := getBucketAndKey(ctx, pg)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		file, err := pg.GetObject(ctx, namespace.ID, key)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		if !file.IsExist() {
+			writeError(ctx, errNoSuchKey)
+			return
+		}
+		common.SetFileHeaders(ctx, file)
+		common.SetTagHeaders(ctx, file.Tags, metaHeaderPrefix)
+		ctx.Header("Content-Length", strconv.FormatInt(file.Size, 10))
+		ctx.Header("Last-Modified", common.LastModified(file).UTC().Format(http.TimeFormat))
+		ctx.Status(200)
+	}
+}
+
+// Handles DeleteObject and AbortMultipartUpload
+func DeleteObject(nodeID int64, pg *postgres.Postgres, storage *storagepkg.Storage) gin.HandlerFunc {
+	deleteBucket := DeleteBucket(pg)
+	abortMultipartUpload := AbortMultipartUpload(nodeID, pg, storage)
+
+	return func(ctx *gin.Context) {
+		if objectKey(ctx) == "" {
+			deleteBucket(ctx)
+			return
+		}
+		if ctx.Query("uploadId") != "" {
+			abortMultipartUpload(ctx)
+			return
+		}
+
+		namespace, key, err := getBucketAndKey(ctx, pg)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		// deleting missing key isn't an error in s3
+		_, err = pg.DeleteObject(ctx, namespace.ID, key)
+		if err != nil {
+			writeError(ctx, err)
+			return
+		}
+		ctx.Status(204)
+	}
+}
+
+// Handles CreateMultipartUpload and CompleteMultipartUpload
+func PostObject(nodeID int64, pg *postgres.Postgres, storage *storagepkg.Storage, multipartLifetime time.Duration) gin.HandlerFunc {
+	createMultipartUpload := CreateMultipartUpload(nodeID, pg, multipartLifetime)
+	completeMultipartUpload := CompleteMultipartUpload(nodeID, pg, storage)
+
+	return func(ctx *gin.Context) {
+		_, uploads := ctx.GetQuery("uploads")
+		switch {
+		case uploads:
+			createMultipartUpload(ctx)
+		case ctx.Query("uploadId") != "":
+			completeMultipartUpload(ctx)
+		default:
+			writeError(ctx, errNotImplemented)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package s3
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	streamingPayload                = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingPayloadTrailer         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	streamingUnsignedPayloadTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+	maxChunkSize                    = 16 << 20
+	maxChunkLineSize                = 4096
+)
+
+// Body of request with values it must match after it's read
+type payload struct {
+	io.Reader
+	// hex encoded sha256, empty if payload isn't signed
+	sha256 string
+	// -1 if unknown
+	size int64
+}
+
+// Returns decoded body of request, chunk signatures are verified while reading
+func getPayload(ctx *gin.Context) (p payload, err error) {
+	p = payload{Reader: ctx.Request.Body, size: -1}
+	contentSHA256 := ctx.GetHeader("X-Amz-Content-Sha256")
+	switch contentSHA256 {
+	case "", unsignedPayload:
+	case streamingPayload, streamingPayloadTrailer, streamingUnsignedPayloadTrailer:
+		var sc *signingContext
+		if contentSHA256 != streamingUnsignedPayloadTrailer {
+			sc = ctx.MustGet(signingContextKey).(*signingContext)
+		}
+		p.Reader = newChunkedReader(ctx.Request.Body, sc)
+		p.size, err = strconv.ParseInt(ctx.GetHeader("X-Amz-Decoded-Content-Length"), 10, 64)
+		if err != nil {
+			err = errInvalidArgument
+		}
+	default:
+		p.sha256 = strings.ToLower(contentSHA256)
+	}
+	return
+}
+
+// Checks that read payload matches signed size and checksum
+func (p payload) verify(size int64, checksum string) error {
+	if p.size >= 0 && size != p.size {
+		return errIncompleteBody
+	}
+	if p.sha256 != "" && checksum != p.sha256 {
+		return errContentSHA256Mismatch
+	}
+	return nil
+}
+
+// Decodes aws-chunked body, signing context is nil for unsigned chunks.
+// Trailing headers with checksums are skipped.
+type chunkedReader struct {
+	r         *bufio.Reader
+	sc        *signingContext
+	signature string
+	buf       []byte
+	chunk     []byte
+	eof       bool
+}
+
+func newChunkedReader(r io.Reader, sc *signingContext) *chunkedReader {
+	cr := &chunkedReader{r: bufio.NewReader(r), sc: sc}
+	if sc != nil {
+		cr.signature = sc.signature
+	}
+	return cr
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	for len(cr.chunk) == 0 {
+		if cr.eof {
+			return 0, io.EOF
+		}
+		err := cr.nextChunk()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, cr.chunk)
+	cr.chunk = cr.chunk[n:]
+	return n, nil
+}
+
+func (cr *chunkedReader) readLine() (string, error) {
+	line, err := cr.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) || len(line) > maxChunkLineSize {
+		return "", errIncompleteBody
+	}
+	if err != nil && !(errors.Is(err, io.EOF) && len(line) > 0) {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// Reads chunk "<hex size>[;chunk-signature=<signature>]\r\n<data>\r\n"
+func (cr *chunkedReader) nextChunk() error {
+	line, err := cr.readLine()
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	sizeHex, extension, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(sizeHex, 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return errIncompleteBody
+	}
+	if int64(cap(cr.buf)) < size {
+		cr.buf = make([]byte, size)
+	}
+	data := cr.buf[:size]
+	_, err = io.ReadFull(cr.r, data)
+	if err != nil {
+		return err
+	}
+
+	if cr.sc != nil {
+		signature, ok := strings.CutPrefix(extension, "chunk-signature=")
+		expected := cr.sc.chunkSignature(cr.signature, data)
+		if !ok || !hmac.Equal([]byte(signature), []byte(expected)) {
+			return errSignatureDoesNotMatch
+		}
+		cr.signature = signature
+	}
+
+	if size == 0 {
+		// trailing headers end with empty line
+		for {
+			line, err = cr.readLine()
+			if errors.Is(err, io.EOF) || (err == nil && line == "") {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		cr.eof = true
+		return nil
+	}
+	line, err = cr.readLine()
+	if err != nil || line != "" {
+		return errIncompleteBody
+	}
+	cr.chunk = data
+	return nil
+}
@@ -0,0 +1,151 @@
+package s3
+
+import (
+	"encoding/xml"
+	"errors"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muskelo/bronze-pheasant/app/server/httpapi/common"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+	locklib "github.com/muskelo/bronze-pheasant/lib/lock"
+)
+
+// S3 compatible api with path style addressing, buckets are namespaces and objects are files with key
+
+const xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// Credentials of the only s3 user
+type Credentials struct {
+	AccessKey string
+	SecretKey string
+}
+
+// Error returned to client as s3 error document
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.code + ": " + e.message
+}
+
+var (
+	errAccessDenied          = &apiError{403, "AccessDenied", "Access Denied"}
+	errInvalidAccessKeyID    = &apiError{403, "InvalidAccessKeyId", "The access key Id you provided does not exist"}
+	errSignatureDoesNotMatch = &apiError{403, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided"}
+	errRequestTimeTooSkewed  = &apiError{403, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large"}
+	errExpiredToken          = &apiError{403, "AccessDenied", "Request has expired"}
+	errMalformedAuth         = &apiError{400, "AuthorizationHeaderMalformed", "The authorization header is malformed"}
+	errInvalidBucketName     = &apiError{400, "InvalidBucketName", "The specified bucket is not valid"}
+	errNoSuchBucket          = &apiError{404, "NoSuchBucket", "The specified bucket does not exist"}
+	errBucketAlreadyOwned    = &apiError{409, "BucketAlreadyOwnedByYou", "Your previous request to create the named bucket succeeded"}
+	errBucketNotEmpty        = &apiError{409, "BucketNotEmpty", "The bucket you tried to delete is not empty"}
+	errInvalidKey            = &apiError{400, "KeyTooLongError", "Your key is too long or isn't valid utf-8"}
+	errNoSuchKey             = &apiError{404, "NoSuchKey", "The specified key does not exist"}
+	errNoSuchUpload          = &apiError{404, "NoSuchUpload", "The specified multipart upload does not exist"}
+	errUploadOnAnotherNode   = &apiError{400, "InvalidRequest", "Multipart upload is stored on another node, send all requests of upload to the node which created it"}
+	errInvalidPart           = &apiError{400, "InvalidPart", "One or more of the specified parts could not be found"}
+	errInvalidPartOrder      = &apiError{400, "InvalidPartOrder", "The list of parts was not in ascending order"}
+	errInvalidArgument       = &apiError{400, "InvalidArgument", "Invalid argument"}
+	errMalformedXML          = &apiError{400, "MalformedXML", "The XML you provided was not well-formed"}
+	errContentSHA256Mismatch = &apiError{400, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed"}
+	errIncompleteBody        = &apiError{400, "IncompleteBody", "You did not provide the number of bytes specified by the Content-Length HTTP header"}
+	errNotImplemented        = &apiError{501, "NotImplemented", "A header you provided implies functionality that is not implemented"}
+	errInternal              = &apiError{500, "InternalError", "We encountered an internal error. Please try again"}
+	errServiceUnavailable    = &apiError{503, "ServiceUnavailable", "Node is read-only, please retry"}
+)
+
+type errorResponse struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+// Sends s3 error document, errors which aren't apiError are logged and reported as InternalError
+func writeError(ctx *gin.Context, err error) {
+	apiErr := &apiError{}
+	if !errors.As(err, &apiErr) {
+		common.Log.Error(err.Error())
+		apiErr = errInternal
+	}
+	ctx.XML(apiErr.status, errorResponse{
+		Code:     apiErr.code,
+		Message:  apiErr.message,
+		Resource: ctx.Request.URL.Path,
+	})
+}
+
+// Rejects writes while node lock isn't fresh
+func ReadOnlyWhenStale(lock locklib.Lock) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		switch ctx.Request.Method {
+		case "PUT", "POST", "DELETE":
+			if !lock.IsFresh() {
+				writeError(ctx, errServiceUnavailable)
+				ctx.Abort()
+			}
+		}
+	}
+}
+
+// Returns key of object from path, empty key means request to bucket
+func objectKey(ctx *gin.Context) string {
+	return strings.TrimPrefix(ctx.Param("key"), "/")
+}
+
+// Returns namespace of bucket from path
+func getBucket(ctx *gin.Context, pg *postgres.Postgres) (namespace postgres.Namespace, err error) {
+	name := ctx.Param("bucket")
+	if !common.IsValidNamespace(name) {
+		err = errInvalidBucketName
+		return
+	}
+	namespace, err = pg.GetNamespaceByName(ctx, name)
+	if err != nil {
+		return
+	}
+	if !namespace.IsExist() {
+		err = errNoSuchBucket
+	}
+	return
+}
+
+// Returns namespace and key of object from path
+func getBucketAndKey(ctx *gin.Context, pg *postgres.Postgres) (namespace postgres.Namespace, key string, err error) {
+	key = objectKey(ctx)
+	if !common.IsValidKey(key) {
+		err = errInvalidKey
+		return
+	}
+	namespace, err = getBucket(ctx, pg)
+	return
+}
+
+// Prefix of headers with user metadata, it's stored as tags
+const metaHeaderPrefix = "X-Amz-Meta-"
+
+// Returns metadata of object from request headers
+func requestMetadata(ctx *gin.Context) (metadata postgres.FileMetadata, err error) {
+	metadata.ContentType = ctx.GetHeader("Content-Type")
+	metadata.Tags = common.TagsFromHeaders(ctx.Request.Header, metaHeaderPrefix)
+	if disposition := ctx.GetHeader("Content-Disposition"); disposition != "" {
+		_, params, parseErr := mime.ParseMediaType(disposition)
+		if parseErr == nil {
+			metadata.Filename = params["filename"]
+		}
+	}
+	if common.CheckMetadata(metadata) != nil {
+		err = errInvalidArgument
+	}
+	return
+}
+
+func formatTime(unix int64) string {
+	return time.Unix(unix, 0).UTC().Format("2006-01-02T15:04:05.000Z")
+}
@@ -15,13 +15,16 @@ import (
 	"github.com/muskelo/bronze-pheasant/app/server/log"
 	"github.com/muskelo/bronze-pheasant/app/server/pglock"
 	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+	"github.com/muskelo/bronze-pheasant/app/server/rebalance"
+	"github.com/muskelo/bronze-pheasant/app/server/scrubber"
 	"github.com/muskelo/bronze-pheasant/app/server/storage"
+	"github.com/muskelo/bronze-pheasant/app/server/storagegc"
 	"github.com/muskelo/bronze-pheasant/app/server/syncm"
 )
 
 var (
 	name          = kingpin.Flag("name", "Node name").Required().String()
-	advertiseAddr = kingpin.Flag("advertise-addr", "Advertise addr").Required().String()
+	advertiseAddr = kingpin.Flag("advertise-addr", "Advertise addr of internal api, e.g. https://node1:3001").Required().String()
 )
 
 var (
@@ -53,7 +56,12 @@ func startup(ctx context.Context) error {
 	}
 
 	log.G("startup").Info("Init lock")
-	pglock.Init(node.ID)
+	err = pglock.Init(node.ID)
+	if err != nil {
+		log.G("startup").Errorf("Failed init lock: %v\n", err)
+		return err
+	}
+	postgres.Default.SetLock(pglock.Default)
 
 	log.G("startup").Info("Taking lock")
 	err = pglock.Default.Take(ctx)
@@ -71,12 +79,34 @@ func startup(ctx context.Context) error {
 
 	log.G("startup").Info("Create storage")
 	err = storage.Init()
+	if err != nil {
+		log.G("startup").Errorf("Failed create storage: %v\n", err)
+		return err
+	}
 
-	log.G("startup").Printf("Create http server")
-	httpapi.Init(node.ID)
+	log.G("startup").Info("Create storage gc")
+	storagegc.Init(node.ID)
+	storagegc.Default.ClearTmpfiles()
 
 	log.G("startup").Print("Create syncmanager")
-	syncm.Init(node.ID)
+	err = syncm.Init(node.ID)
+	if err != nil {
+		log.G("startup").Errorf("Failed create syncmanager: %v\n", err)
+		return err
+	}
+
+	log.G("startup").Print("Create rebalancer")
+	rebalance.Init(node.ID)
+
+	log.G("startup").Printf("Create http server")
+	err = httpapi.Init(node.ID)
+	if err != nil {
+		log.G("startup").Errorf("Failed create http server: %v\n", err)
+		return err
+	}
+
+	log.G("startup").Print("Create scrubber")
+	scrubber.Init(node.ID)
 
 	return nil
 }
@@ -112,6 +142,19 @@ func run(ctx context.Context) error {
 		log.G("httpapi").Printf("Shutdown signal")
 		return httpapi.Default.Shutdown(ctx)
 	})
+	group.Go(func() error {
+		log.G("httpapi").Printf("Start internal listen and serve on %s\n", httpapi.DefaultInternal.Addr)
+		err := httpapi.ListenAndServe(httpapi.DefaultInternal)
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		}
+		log.G("httpapi").Printf("Stop internal listen and serve (%v)\n", err)
+		return err
+	})
+	group.Go(func() error {
+		<-ctx.Done()
+		return httpapi.DefaultInternal.Shutdown(ctx)
+	})
 
 	log.G("run").Print("Start 'syncmanager' goroutine")
 	group.Go(func() error {
@@ -121,6 +164,30 @@ func run(ctx context.Context) error {
 		return err
 	})
 
+	log.G("run").Print("Start 'storagegc' goroutine")
+	group.Go(func() error {
+		log.G("storagegc").Print("Started garbage collection")
+		err := storagegc.Default.Run(ctx)
+		log.G("storagegc").Printf("Stop (%v)", err)
+		return err
+	})
+
+	log.G("run").Print("Start 'scrubber' goroutine")
+	group.Go(func() error {
+		log.G("scrubber").Print("Started scrubbing")
+		err := scrubber.Default.Run(ctx)
+		log.G("scrubber").Printf("Stop (%v)", err)
+		return err
+	})
+
+	log.G("run").Print("Start 'rebalancer' goroutine")
+	group.Go(func() error {
+		log.G("rebalancer").Print("Started rebalancing")
+		err := rebalance.Default.Run(ctx)
+		log.G("rebalancer").Printf("Stop (%v)", err)
+		return err
+	})
+
 	if err := group.Wait(); err != nil {
 		log.G("run").Printf("%s \n", err)
 	}
@@ -128,7 +195,7 @@ func run(ctx context.Context) error {
 }
 
 func shutdown(ctx context.Context) {
-	if pglock.Default.GetUnix() > 0 {
+	if pglock.Default != nil && pglock.Default.IsHeld() {
 		log.G("shutdown").Info("Release lock")
 		err := pglock.Default.Release(context.Background())
 		if err != nil {
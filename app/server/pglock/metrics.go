@@ -0,0 +1,29 @@
+package pglock
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var lockUpdateFailures = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "bronze_pheasant_lock_update_failures_total",
+	Help: "Number of failed updates of node lock",
+})
+
+// Registers gauges with age of lock and time until it expires
+func registerMetrics(l *Lock) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "bronze_pheasant_lock_heartbeat_age_seconds",
+		Help: "Time since node lock was taken or updated",
+	}, func() float64 {
+		return time.Since(l.lockTime()).Seconds()
+	}))
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "bronze_pheasant_lock_expiry_seconds",
+		Help: "Time until node lock expires, negative if it's expired",
+	}, func() float64 {
+		return time.Until(l.lockTime().Add(l.lifetimeDuration)).Seconds()
+	}))
+}
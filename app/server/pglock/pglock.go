@@ -4,55 +4,80 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/muskelo/bronze-pheasant/app/server/log"
 	"github.com/muskelo/bronze-pheasant/app/server/postgres"
 )
 
-const (
-	LifetimeSeconds       = int64(60)
-	UpdateIntervalSeconds = int64(30)
-	FreshSeconds          = int64(45)
-	TimeoutSeconds        = int64(10)
-)
-
 func New(
 	nodeID int64,
 	pg *postgres.Postgres,
+	lifetime time.Duration,
+	updateInterval time.Duration,
+	fresh time.Duration,
+	timeout time.Duration,
+	retryInterval time.Duration,
 ) *Lock {
 	return &Lock{
 		nodeID: nodeID,
 		pg:     pg,
 
-		lifetimeDuration:       time.Duration(LifetimeSeconds) * time.Second,
-		updateIntervalDuration: time.Duration(UpdateIntervalSeconds) * time.Second,
-		timeoutDuration:        time.Duration(TimeoutSeconds) * time.Second,
-		freshDuration:          time.Duration(FreshSeconds) * time.Second,
+		lifetimeDuration:       lifetime,
+		updateIntervalDuration: updateInterval,
+		timeoutDuration:        timeout,
+		retryIntervalDuration:  retryInterval,
+		freshDuration:          fresh,
 	}
 }
 
+// Lease of node lock. Postgres computes expiry of lease with its own time, node measures
+// freshness with monotonic clock from moment the lease was requested, so it sees lease
+// expired before postgres does and clock skew between nodes doesn't matter.
 type Lock struct {
 	nodeID int64
 	pg     *postgres.Postgres
 
-	lock   time.Time
+	// local time when lease was requested last time, nil if lock isn't held.
+	// It's read without mutex like epoch, so requests aren't blocked while lock is updated
+	lock atomic.Pointer[time.Time]
+	// fencing token, grows every time lock is taken. It's read without mutex,
+	// so writes aren't blocked while lock is updated
+	epoch  atomic.Int64
 	mutext sync.Mutex
 
 	lifetimeDuration       time.Duration
 	updateIntervalDuration time.Duration
 	timeoutDuration        time.Duration
+	retryIntervalDuration  time.Duration
 	freshDuration          time.Duration
 }
 
 func (l *Lock) NextLock() time.Time {
-	return l.lock.Add(l.updateIntervalDuration)
+	return l.lockTime().Add(l.updateIntervalDuration)
 }
 func (l *Lock) UntilNextLock() time.Duration {
 	return time.Until(l.NextLock())
 }
 
-func (l *Lock) GetUnix() int64 {
-	return l.lock.Unix()
+func (l *Lock) lockTime() time.Time {
+	lock := l.lock.Load()
+	if lock == nil {
+		return time.Time{}
+	}
+	return *lock
+}
+
+// Returns id of node and epoch of lock, writes to postgres are fenced by them
+func (l *Lock) Fence() (int64, int64) {
+	return l.nodeID, l.epoch.Load()
+}
+
+// Returns true if lock was taken and isn't released
+func (l *Lock) IsHeld() bool {
+	return !l.lockTime().IsZero()
 }
 
 func (l *Lock) innerTake(ctx context.Context) error {
@@ -60,16 +85,13 @@ func (l *Lock) innerTake(ctx context.Context) error {
 	defer l.mutext.Unlock()
 
 	lock := time.Now()
-	lockLower := lock.Add(-l.lifetimeDuration)
-	n, err := l.pg.TakeNodeLock(ctx, lock.Unix(), l.nodeID, lockLower.Unix())
+	epoch, err := l.pg.TakeNodeLock(ctx, l.nodeID, l.lifetimeDuration)
 	if err != nil {
 		return err
 	}
-	if n != 1 {
-		return fmt.Errorf("Failed take lock (%v)\n", n)
-	}
 
-	l.lock = lock
+	l.epoch.Store(epoch)
+	l.lock.Store(&lock)
 	return nil
 }
 
@@ -77,9 +99,9 @@ func (l *Lock) innerRelease(ctx context.Context) error {
 	l.mutext.Lock()
 	defer l.mutext.Unlock()
 
-	err := l.pg.ReleaseNodeLock(ctx, l.nodeID, l.lock.Unix())
+	err := l.pg.ReleaseNodeLock(ctx, l.nodeID, l.epoch.Load())
 	if err == nil {
-		l.lock = time.Time{}
+		l.lock.Store(nil)
 	}
 	return err
 }
@@ -88,11 +110,10 @@ func (l *Lock) innerUpdate(ctx context.Context) error {
 	l.mutext.Lock()
 	defer l.mutext.Unlock()
 
-	oldLock := l.lock
 	newLock := time.Now()
-	err := l.pg.UpdateNodeLock(ctx, newLock.Unix(), l.nodeID, oldLock.Unix())
+	err := l.pg.UpdateNodeLock(ctx, l.nodeID, l.epoch.Load(), l.lifetimeDuration)
 	if err == nil {
-		l.lock = newLock
+		l.lock.Store(&newLock)
 	}
 	return err
 }
@@ -109,23 +130,39 @@ func (l *Lock) Update(ctx context.Context) error {
 	return ExecWithTimeout(ctx, l.timeoutDuration, l.innerUpdate)
 }
 
+// Updates lock until ctx is done. Failed updates are retried, lock which isn't fresh
+// is taken again, node stays read-only until it gets lock back.
 func (l *Lock) Keep(ctx context.Context) error {
 	for {
 		err := l.Update(ctx)
 		if err != nil {
-			return err
+			lockUpdateFailures.Inc()
+			log.G("lock").Errorf("Failed update lock: %v", err)
+			if !l.IsFresh() {
+				err = l.Take(ctx)
+				if err != nil {
+					log.G("lock").Errorf("Failed take lock: %v", err)
+				} else {
+					log.G("lock").Info("Lock is taken again")
+				}
+			}
 		}
 
+		wait := l.UntilNextLock()
+		if err != nil {
+			wait = l.retryIntervalDuration
+		}
 		select {
 		case <-ctx.Done():
 			return nil
-		case <-time.After(l.UntilNextLock()):
+		case <-time.After(wait):
 		}
 	}
 }
 
 func (l *Lock) IsFresh() bool {
-	return time.Now().Before(l.lock.Add(l.freshDuration))
+	lock := l.lockTime()
+	return !lock.IsZero() && time.Since(lock) < l.freshDuration
 }
 
 // Default lock instance
@@ -134,8 +171,27 @@ var (
 	Default *Lock
 )
 
-func Init(nodeID int64) {
-    Default = New(nodeID, postgres.Default)
+var (
+	lockLifetime       = kingpin.Flag("lock.lifetime", "Lifetime of node lease, node is considered dead when it expires").Default("60s").Duration()
+	lockUpdateInterval = kingpin.Flag("lock.update-interval", "Interval between updates of node lease").Default("30s").Duration()
+	lockFresh          = kingpin.Flag("lock.fresh", "Node becomes read-only if lease wasn't updated for this time, must be less than lifetime").Default("45s").Duration()
+	lockTimeout        = kingpin.Flag("lock.timeout", "Timeout of lease updates").Default("10s").Duration()
+	lockRetryInterval  = kingpin.Flag("lock.retry-interval", "Interval between retries of failed lease updates").Default("5s").Duration()
+)
+
+func Init(nodeID int64) error {
+	if *lockUpdateInterval <= 0 || *lockUpdateInterval >= *lockFresh {
+		return fmt.Errorf("Lock update interval must be positive and less than fresh duration")
+	}
+	if *lockFresh >= *lockLifetime {
+		return fmt.Errorf("Lock fresh duration must be less than lifetime")
+	}
+	if *lockTimeout <= 0 || *lockRetryInterval <= 0 {
+		return fmt.Errorf("Lock timeout and retry interval must be positive")
+	}
+	Default = New(nodeID, postgres.Default, *lockLifetime, *lockUpdateInterval, *lockFresh, *lockTimeout, *lockRetryInterval)
+	registerMetrics(Default)
+	return nil
 }
 
 // Other
@@ -143,7 +199,8 @@ func Init(nodeID int64) {
 type Callback func(context.Context) error
 
 func ExecWithTimeout(ctx context.Context, timeout time.Duration, callback Callback) error {
-	ctx, _ = context.WithTimeout(ctx, timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
 	errc := make(chan error, 1)
 	go func(ctx context.Context) {
@@ -0,0 +1,161 @@
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	locklib "github.com/muskelo/bronze-pheasant/lib/lock"
+)
+
+// Scopes of api key, admin scope grants every other scope
+const (
+	APIKeyScopeRead   = "read"
+	APIKeyScopeWrite  = "write"
+	APIKeyScopeDelete = "delete"
+	APIKeyScopeAdmin  = "admin"
+)
+
+var APIKeyScopes = []string{APIKeyScopeRead, APIKeyScopeWrite, APIKeyScopeDelete, APIKeyScopeAdmin}
+
+const apiKeyPrefix = "bp_"
+
+// Key for external api, only sha256 of key is stored
+type APIKey struct {
+	ID     int64
+	Name   string
+	Scopes []string
+	// 0 if key isn't restricted to namespace
+	NamespaceID int64
+	Created_at  int64
+	Revoked_at  int64
+	notExist    bool
+}
+
+func (key APIKey) IsExist() bool {
+	return !key.notExist
+}
+
+func (key APIKey) IsRevoked() bool {
+	return key.Revoked_at != 0
+}
+
+func (key APIKey) HasScope(scope string) bool {
+	for _, s := range key.Scopes {
+		if s == scope || s == APIKeyScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns new random api key and its hash
+func GenerateAPIKey() (key string, hash string, err error) {
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return
+	}
+	key = apiKeyPrefix + hex.EncodeToString(b)
+	hash = HashAPIKey(key)
+	return
+}
+
+// Keys are random, so plain sha256 is enough to not keep them in database
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+const apiKeyColumns = `api_key.id, api_key.name, api_key.scopes, COALESCE(api_key.namespace_id, 0), api_key.created_at, api_key.revoked_at`
+
+func scanAPIKey(row pgx.Row, key *APIKey) error {
+	return row.Scan(
+		&key.ID,
+		&key.Name,
+		&key.Scopes,
+		&key.NamespaceID,
+		&key.Created_at,
+		&key.Revoked_at,
+	)
+}
+
+// Creates api key, namespaceID 0 means key has access to every namespace
+func (pg *Postgres) CreateAPIKey(ctx context.Context, name string, hash string, scopes []string, namespaceID int64) (key APIKey, err error) {
+	const createAPIKeySQL = `
+        INSERT INTO api_key
+        (name, hash, scopes, namespace_id, created_at)
+        SELECT $1, $2, $3, NULLIF($4::int8, 0), $5
+        WHERE ($6::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$6 AND node.epoch=$7 FOR SHARE))
+        RETURNING ` + apiKeyColumns + `;
+    `
+
+	fenceNodeID, epoch := pg.fence()
+	err = scanAPIKey(pg.pool.QueryRow(ctx, createAPIKeySQL, name, hash, scopes, namespaceID, time.Now().Unix(), fenceNodeID, epoch), &key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = locklib.ErrLockExpired
+	}
+	return
+}
+
+func (pg *Postgres) GetAPIKeyByHash(ctx context.Context, hash string) (key APIKey, err error) {
+	const getAPIKeyByHashSQL = `
+        SELECT ` + apiKeyColumns + `
+        FROM api_key
+        WHERE hash=$1
+    `
+
+	err = scanAPIKey(pg.pool.QueryRow(ctx, getAPIKeyByHashSQL, hash), &key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = nil
+		key.notExist = true
+	}
+	return
+}
+
+func (pg *Postgres) GetAPIKeys(ctx context.Context) (keys []APIKey, err error) {
+	const getAPIKeysSQL = `
+        SELECT ` + apiKeyColumns + `
+        FROM api_key
+        ORDER BY id;
+    `
+
+	rows, err := pg.pool.Query(ctx, getAPIKeysSQL)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		key := APIKey{}
+		err = scanAPIKey(rows, &key)
+		if err != nil {
+			return
+		}
+		keys = append(keys, key)
+	}
+	err = rows.Err()
+	return
+}
+
+// Revokes api key, revoked keys are kept to show them in list
+func (pg *Postgres) RevokeAPIKey(ctx context.Context, id int64) (key APIKey, err error) {
+	const revokeAPIKeySQL = `
+        UPDATE api_key
+        SET revoked_at=$2
+        WHERE id=$1 AND revoked_at=0
+            AND ($3::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$3 AND node.epoch=$4 FOR SHARE))
+        RETURNING ` + apiKeyColumns + `;
+    `
+
+	fenceNodeID, epoch := pg.fence()
+	err = scanAPIKey(pg.pool.QueryRow(ctx, revokeAPIKeySQL, id, time.Now().Unix(), fenceNodeID, epoch), &key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = pg.checkFence(ctx, fenceNodeID, epoch)
+		key.notExist = true
+	}
+	return
+}
@@ -0,0 +1,44 @@
+package postgres
+
+import (
+	"context"
+
+	locklib "github.com/muskelo/bronze-pheasant/lib/lock"
+)
+
+// Writes of node are fenced by epoch of its lock, epoch is increased every time the lock is taken.
+// Fenced statements have condition
+//
+//	($n::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$n AND node.epoch=$m FOR SHARE))
+//
+// in their WHERE clause, where $n and $m are values returned by fence, so node which was paused
+// longer than lifetime of lock can't change metadata after another process took the lock.
+// Row of node stays locked until end of transaction, lock isn't taken while write is in progress.
+// Writes of process without lock (ctl) aren't fenced.
+
+// Returns id of node and epoch of lock, 0 if postgres is used without lock
+func (pg *Postgres) fence() (nodeID int64, epoch int64) {
+	if pg.lock == nil {
+		return 0, 0
+	}
+	return pg.lock.Fence()
+}
+
+// Returns ErrLockExpired if lock was taken after epoch, it tells rejected write from
+// missing row when fenced statement affects no rows
+func (pg *Postgres) checkFence(ctx context.Context, nodeID int64, epoch int64) error {
+	const getNodeEpochSQL = `SELECT epoch FROM node WHERE id=$1`
+
+	if nodeID == 0 {
+		return nil
+	}
+	current := int64(0)
+	err := pg.pool.QueryRow(ctx, getNodeEpochSQL, nodeID).Scan(&current)
+	if err != nil {
+		return err
+	}
+	if current != epoch {
+		return locklib.ErrLockExpired
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	uuidp "github.com/google/uuid"
+	locklib "github.com/muskelo/bronze-pheasant/lib/lock"
+	"github.com/stretchr/testify/require"
+)
+
+type testLock struct {
+	nodeID int64
+	epoch  int64
+}
+
+func (l testLock) IsFresh() bool {
+	return true
+}
+
+func (l testLock) Fence() (int64, int64) {
+	return l.nodeID, l.epoch
+}
+
+// Runs write with stale epoch and then with fresh one, rows written by write must be set up
+// so that write would change them without fence. check is called after each run and must tell
+// if write changed rows.
+func testFencedWrite(t *testing.T, pgi *Postgres, lock testLock, name string, write func() error, check func() bool) {
+	t.Helper()
+
+	pgi.SetLock(testLock{nodeID: lock.nodeID, epoch: lock.epoch - 1})
+	err := write()
+	require.ErrorIs(t, err, locklib.ErrLockExpired, "%v must be rejected with stale epoch", name)
+	pgi.SetLock(nil)
+	require.False(t, check(), "%v must not change rows with stale epoch", name)
+
+	pgi.SetLock(lock)
+	err = write()
+	require.NoError(t, err, "%v must succeed with fresh epoch", name)
+	pgi.SetLock(nil)
+	require.True(t, check(), "%v must change rows with fresh epoch", name)
+}
+
+func TestFence(t *testing.T) {
+	ctx := context.Background()
+	pgi := newTestPostgres(t)
+
+	node, err := pgi.CreateNode(ctx, fmt.Sprintf("fence-node-%v", uuidp.NewString()))
+	require.NoError(t, err, "Must create node")
+	epoch, err := pgi.TakeNodeLock(ctx, node.ID, time.Minute)
+	require.NoError(t, err, "Must take lock")
+	lock := testLock{nodeID: node.ID, epoch: epoch}
+
+	// rows are set up without lock
+	createReadyFile := func() File {
+		file, err := pgi.CreateFile(ctx, uuidp.NewString(), 0, 0, FileMetadata{})
+		require.NoError(t, err, "Must create file")
+		file, err = pgi.UpdateFile(ctx, file.ID, FileStateReady, 1000, "")
+		require.NoError(t, err, "Must make file ready")
+		return file
+	}
+	fileState := func(uuid string) int64 {
+		file, err := pgi.GetFileByUUID(ctx, uuid)
+		require.NoError(t, err)
+		return file.State
+	}
+	nodeState := func(id int64) int64 {
+		node, err := pgi.GetNodeByID(ctx, id)
+		require.NoError(t, err)
+		return node.State
+	}
+	isFileOnNode := func(fileID int64) bool {
+		nodes, err := pgi.GetNodesWithinFile(ctx, fileID)
+		require.NoError(t, err)
+		for _, n := range nodes {
+			if n.ID == node.ID {
+				return true
+			}
+		}
+		return false
+	}
+
+	t.Log("DeleteFile")
+	{
+		file := createReadyFile()
+		testFencedWrite(t, pgi, lock, "DeleteFile", func() error {
+			_, err := pgi.DeleteFile(ctx, file.UUID)
+			return err
+		}, func() bool {
+			return fileState(file.UUID) == FileStateDeleted
+		})
+	}
+
+	t.Log("DeleteObject")
+	{
+		namespace, err := pgi.CreateNamespace(ctx, uuidp.NewString())
+		require.NoError(t, err, "Must create namespace")
+		object, err := pgi.CreateObject(ctx, uuidp.NewString(), namespace.ID, "key", 0, FileMetadata{})
+		require.NoError(t, err, "Must create object")
+		_, err = pgi.CommitObject(ctx, object.ID, 1000, "")
+		require.NoError(t, err, "Must commit object")
+		testFencedWrite(t, pgi, lock, "DeleteObject", func() error {
+			_, err := pgi.DeleteObject(ctx, namespace.ID, "key")
+			return err
+		}, func() bool {
+			return fileState(object.UUID) == FileStateDeleted
+		})
+	}
+
+	t.Log("DeleteUpload")
+	{
+		file, err := pgi.CreateFile(ctx, uuidp.NewString(), 0, 0, FileMetadata{})
+		require.NoError(t, err, "Must create file")
+		_, err = pgi.CreateUpload(ctx, file.ID, node.ID, 1000, time.Hour)
+		require.NoError(t, err, "Must create upload")
+		testFencedWrite(t, pgi, lock, "DeleteUpload", func() error {
+			return pgi.DeleteUpload(ctx, file.ID)
+		}, func() bool {
+			upload, err := pgi.GetUploadByUUID(ctx, file.UUID)
+			require.NoError(t, err)
+			return !upload.IsExist()
+		})
+	}
+
+	t.Log("DeleteMultipartUpload")
+	{
+		namespace, err := pgi.CreateNamespace(ctx, uuidp.NewString())
+		require.NoError(t, err, "Must create namespace")
+		upload, err := pgi.CreateMultipartUpload(ctx, uuidp.NewString(), namespace.ID, "key", node.ID, FileMetadata{}, time.Hour)
+		require.NoError(t, err, "Must create multipart upload")
+		testFencedWrite(t, pgi, lock, "DeleteMultipartUpload", func() error {
+			return pgi.DeleteMultipartUpload(ctx, upload.ID)
+		}, func() bool {
+			upload, err := pgi.GetMultipartUpload(ctx, upload.UUID)
+			require.NoError(t, err)
+			return !upload.IsExist()
+		})
+	}
+
+	t.Log("SetSetting")
+	{
+		value := uuidp.NewString()
+		testFencedWrite(t, pgi, lock, "SetSetting", func() error {
+			return pgi.SetSetting(ctx, "fence-test", value)
+		}, func() bool {
+			current, err := pgi.GetSetting(ctx, "fence-test", "")
+			require.NoError(t, err)
+			return current == value
+		})
+	}
+
+	t.Log("UpdateNodeState and DecommissionNode")
+	{
+		target, err := pgi.CreateNode(ctx, fmt.Sprintf("fence-node-%v", uuidp.NewString()))
+		require.NoError(t, err, "Must create node")
+		testFencedWrite(t, pgi, lock, "UpdateNodeState", func() error {
+			_, err := pgi.UpdateNodeState(ctx, target.ID, NodeStateDraining, NodeStateActive)
+			return err
+		}, func() bool {
+			return nodeState(target.ID) == NodeStateDraining
+		})
+		testFencedWrite(t, pgi, lock, "DecommissionNode", func() error {
+			_, err := pgi.DecommissionNode(ctx, target.ID)
+			return err
+		}, func() bool {
+			return nodeState(target.ID) == NodeStateDecommissioned
+		})
+	}
+
+	t.Log("CreateNamespace and DeleteNamespace")
+	{
+		name := uuidp.NewString()
+		testFencedWrite(t, pgi, lock, "CreateNamespace", func() error {
+			_, err := pgi.CreateNamespace(ctx, name)
+			return err
+		}, func() bool {
+			namespace, err := pgi.GetNamespaceByName(ctx, name)
+			require.NoError(t, err)
+			return namespace.IsExist()
+		})
+		namespace, err := pgi.GetNamespaceByName(ctx, name)
+		require.NoError(t, err)
+		namespaceID := namespace.ID
+		testFencedWrite(t, pgi, lock, "DeleteNamespace", func() error {
+			return pgi.DeleteNamespace(ctx, namespaceID)
+		}, func() bool {
+			namespace, err := pgi.GetNamespaceByID(ctx, namespaceID)
+			require.NoError(t, err)
+			return !namespace.IsExist()
+		})
+	}
+
+	t.Log("CreateAPIKey and RevokeAPIKey")
+	{
+		hash := uuidp.NewString()
+		testFencedWrite(t, pgi, lock, "CreateAPIKey", func() error {
+			_, err := pgi.CreateAPIKey(ctx, uuidp.NewString(), hash, nil, 0)
+			return err
+		}, func() bool {
+			key, err := pgi.GetAPIKeyByHash(ctx, hash)
+			require.NoError(t, err)
+			return key.IsExist()
+		})
+		key, err := pgi.GetAPIKeyByHash(ctx, hash)
+		require.NoError(t, err)
+		testFencedWrite(t, pgi, lock, "RevokeAPIKey", func() error {
+			_, err := pgi.RevokeAPIKey(ctx, key.ID)
+			return err
+		}, func() bool {
+			key, err := pgi.GetAPIKeyByHash(ctx, hash)
+			require.NoError(t, err)
+			return key.Revoked_at != 0
+		})
+	}
+
+	t.Log("AddFileToNode")
+	{
+		file := createReadyFile()
+		testFencedWrite(t, pgi, lock, "AddFileToNode", func() error {
+			return pgi.AddFileToNode(ctx, node.ID, file.ID)
+		}, func() bool {
+			return isFileOnNode(file.ID)
+		})
+	}
+
+	t.Log("Fresh epoch tells missing file from expired lock")
+	{
+		pgi.SetLock(lock)
+		err = pgi.AddFileToNode(ctx, node.ID, -1)
+		require.ErrorIs(t, err, ErrFileNotExist)
+		pgi.SetLock(nil)
+	}
+}
+
+func TestPresignSecretFenced(t *testing.T) {
+	ctx := context.Background()
+	pgi := newTestPostgres(t)
+
+	node, err := pgi.CreateNode(ctx, fmt.Sprintf("fence-node-%v", uuidp.NewString()))
+	require.NoError(t, err, "Must create node")
+	epoch, err := pgi.TakeNodeLock(ctx, node.ID, time.Minute)
+	require.NoError(t, err, "Must take lock")
+	_, err = pgi.pool.Exec(ctx, "DELETE FROM setting WHERE name=$1", SettingPresignSecret)
+	require.NoError(t, err)
+
+	pgi.SetLock(testLock{nodeID: node.ID, epoch: epoch - 1})
+	secret, err := pgi.GetPresignSecret(ctx)
+	require.ErrorIs(t, err, locklib.ErrLockExpired, "Empty secret must not be returned")
+	require.Empty(t, secret)
+
+	pgi.SetLock(testLock{nodeID: node.ID, epoch: epoch})
+	secret, err = pgi.GetPresignSecret(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, secret)
+}
@@ -3,10 +3,20 @@ package postgres
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/jackc/pgx/v5"
-	// locklib "github.com/muskelo/bronze-pheasant/lib/lock"
+	locklib "github.com/muskelo/bronze-pheasant/lib/lock"
+)
+
+var ErrFileNotExist = errors.New("File doesn't exist")
+
+// File states
+const (
+	FileStateNew     = int64(0)
+	FileStateReady   = int64(1)
+	FileStateDeleted = int64(2)
 )
 
 type File struct {
@@ -15,110 +25,369 @@ type File struct {
 	State      int64
 	Size       int64
 	Created_at int64
-	notExist   bool
+	Deleted_at int64
+	SHA256     string
+	// 0 means the cluster replication factor
+	ReplicationFactor int64
+	// 0 and empty key for files addressed only by uuid
+	NamespaceID int64
+	Key         string
+	FileMetadata
+	notExist bool
+}
+
+// Metadata provided by client on upload
+type FileMetadata struct {
+	// original name of file
+	Filename    string
+	ContentType string
+	Tags        map[string]string
+}
+
+// Tags stored in jsonb column, nil map would be stored as json null
+func (metadata FileMetadata) tags() map[string]string {
+	if metadata.Tags == nil {
+		return map[string]string{}
+	}
+	return metadata.Tags
 }
 
 func (file File) IsExist() bool {
 	return !file.notExist
 }
 
-func (pg *Postgres) CreateFile(ctx context.Context, uuid string, size int64) (file File, err error) {
+func (file File) IsDeleted() bool {
+	return file.State == FileStateDeleted
+}
+
+// Columns expected by scanFile, additional columns can follow them
+const fileColumns = `file.id, file.uuid, file.state, file.size, file.created_at, file.deleted_at, file.sha256, file.replication_factor,
+        COALESCE(file.namespace_id, 0), COALESCE(file.key, ''), file.filename, file.content_type, file.tags`
+
+func scanFile(row pgx.Row, file *File, dest ...any) error {
+	return row.Scan(append([]any{
+		&file.ID,
+		&file.UUID,
+		&file.State,
+		&file.Size,
+		&file.Created_at,
+		&file.Deleted_at,
+		&file.SHA256,
+		&file.ReplicationFactor,
+		&file.NamespaceID,
+		&file.Key,
+		&file.Filename,
+		&file.ContentType,
+		&file.Tags,
+	}, dest...)...)
+}
+
+func (pg *Postgres) CreateFile(ctx context.Context, uuid string, size int64, replicationFactor int64, metadata FileMetadata) (file File, err error) {
 	const createFileSQL = `
         INSERT INTO file
-        (uuid, state, size, created_at)
-        VALUES($1, $2, $3, $4)
-        RETURNING id, uuid, state, size, created_at;
+        (uuid, state, size, created_at, replication_factor, filename, content_type, tags)
+        SELECT $1, $2, $3, $4, $5, $6, $7, $8
+        WHERE $9::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$9 AND node.epoch=$10 FOR SHARE)
+        RETURNING ` + fileColumns + `;
     `
 
-	// if !pg.lock.IsFresh() {
-	// 	err = locklib.ErrLockExpired
-	// 	return
-	// }
-	err = pg.pool.QueryRow(ctx, createFileSQL, uuid, 0, size, time.Now().Unix()).
-		Scan(
-			&file.ID,
-			&file.UUID,
-			&file.State,
-			&file.Size,
-			&file.Created_at,
-		)
+	err = pg.checkLock()
+	if err != nil {
+		return
+	}
+	nodeID, epoch := pg.fence()
+	err = scanFile(pg.pool.QueryRow(ctx, createFileSQL, uuid, FileStateNew, size, time.Now().Unix(), replicationFactor,
+		metadata.Filename, metadata.ContentType, metadata.tags(), nodeID, epoch), &file)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = locklib.ErrLockExpired
+	}
 	return
 }
 
-func (pg *Postgres) UpdateFile(ctx context.Context, id int64, state int64, size int64) (file File, err error) {
+func (pg *Postgres) UpdateFile(ctx context.Context, id int64, state int64, size int64, sha256 string) (file File, err error) {
 	const updateFileSQL = `
         UPDATE file
-        SET state=$2, size=$3
-        WHERE id=$1
-        RETURNING id, uuid, state, size, created_at;
+        SET state=$2, size=$3, sha256=$4
+        WHERE id=$1 AND ($5::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$5 AND node.epoch=$6 FOR SHARE))
+        RETURNING ` + fileColumns + `;
     `
 
-	// if !pg.lock.IsFresh() {
-	// 	err = locklib.ErrLockExpired
-	// 	return
-	// }
-	err = pg.pool.QueryRow(ctx, updateFileSQL, id, state, size).
-		Scan(
-			&file.ID,
-			&file.UUID,
-			&file.State,
-			&file.Size,
-			&file.Created_at,
-		)
+	err = pg.checkLock()
+	if err != nil {
+		return
+	}
+	nodeID, epoch := pg.fence()
+	err = scanFile(pg.pool.QueryRow(ctx, updateFileSQL, id, state, size, sha256, nodeID, epoch), &file)
 	if errors.Is(err, pgx.ErrNoRows) {
-		err = nil
+		err = pg.checkFence(ctx, nodeID, epoch)
 		file.notExist = true
 	}
 	return
 }
 
-func (pg *Postgres) GetNotSyncedFiles(ctx context.Context, nodeID int64) (files []File, err error) {
-	const getNotSyncedFilesSQL = `
-        SELECT file.id, file.uuid, file.state, file.size, file.created_at
-        FROM file 
-        LEFT JOIN (
-                SELECT file_id 
+// Removes file which is still being uploaded together with its copies on nodes,
+// so upload can be retried with the same uuid
+func (pg *Postgres) AbortFile(ctx context.Context, id int64) error {
+	const abortFileSQL = `
+        DELETE FROM file
+        WHERE id=$1 AND state=$2
+            AND ($3::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$3 AND node.epoch=$4 FOR SHARE));
+    `
+
+	nodeID, epoch := pg.fence()
+	commandTag, err := pg.pool.Exec(ctx, abortFileSQL, id, FileStateNew, nodeID, epoch)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return pg.checkFence(ctx, nodeID, epoch)
+	}
+	return nil
+}
+
+// Marks ready file as deleted, the data itself is removed by sync managers of nodes
+func (pg *Postgres) DeleteFile(ctx context.Context, uuid string) (file File, err error) {
+	const deleteFileSQL = `
+        UPDATE file
+        SET state=$2, deleted_at=$3
+        WHERE uuid=$1 AND state=$4
+            AND ($5::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$5 AND node.epoch=$6 FOR SHARE))
+        RETURNING ` + fileColumns + `;
+    `
+
+	nodeID, epoch := pg.fence()
+	err = scanFile(pg.pool.QueryRow(ctx, deleteFileSQL, uuid, FileStateDeleted, time.Now().Unix(), FileStateReady, nodeID, epoch), &file)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = pg.checkFence(ctx, nodeID, epoch)
+		file.notExist = true
+	}
+	return
+}
+
+// Ready file with ids of active nodes where it's present
+type ReplicatedFile struct {
+	File
+	Holders []int64
+}
+
+// Returns up to limit ready files with id greater than after ordered by id, which node doesn't
+// have and which have less copies on active nodes than their replication factor,
+// replicationFactor is used for files without own one, 0 means file must be present on every
+// node. Copies on draining nodes aren't counted.
+func (pg *Postgres) GetUnderReplicatedFiles(ctx context.Context, nodeID int64, replicationFactor int64, after int64, limit int64) ([]ReplicatedFile, error) {
+	const getUnderReplicatedFilesSQL = `
+        SELECT ` + fileColumns + `, COALESCE(array_agg(node.id) FILTER (WHERE node.id IS NOT NULL), '{}')
+        FROM file
+            LEFT JOIN node_file ON file.id=node_file.file_id
+            LEFT JOIN node ON node_file.node_id=node.id AND node.lease_expires_at > now() AND node.state=$4
+        WHERE file.state=$2 AND file.id>$5 AND NOT EXISTS (
+                SELECT 1
                 FROM node_file
-                WHERE node_id=$1
-            ) AS v
-        ON file.id=v.file_id
-        WHERE file.state=1 AND v.file_id IS NULL;
+                WHERE node_file.file_id=file.id AND node_file.node_id=$1
+            )
+        GROUP BY file.id
+        HAVING COALESCE(NULLIF(file.replication_factor, 0), $3) = 0
+            OR count(node.id) < COALESCE(NULLIF(file.replication_factor, 0), $3)
+        ORDER BY file.id
+        LIMIT $6;
+    `
+
+	return pg.getUnderReplicatedFiles(ctx, getUnderReplicatedFilesSQL, nodeID, replicationFactor, after, limit)
+}
+
+// Same as GetUnderReplicatedFiles but only for file with uuid
+func (pg *Postgres) GetUnderReplicatedFile(ctx context.Context, nodeID int64, replicationFactor int64, uuid string) (file ReplicatedFile, err error) {
+	const getUnderReplicatedFileSQL = `
+        SELECT ` + fileColumns + `, COALESCE(array_agg(node.id) FILTER (WHERE node.id IS NOT NULL), '{}')
+        FROM file
+            LEFT JOIN node_file ON file.id=node_file.file_id
+            LEFT JOIN node ON node_file.node_id=node.id AND node.lease_expires_at > now() AND node.state=$4
+        WHERE file.state=$2 AND file.uuid=$5 AND NOT EXISTS (
+                SELECT 1
+                FROM node_file
+                WHERE node_file.file_id=file.id AND node_file.node_id=$1
+            )
+        GROUP BY file.id
+        HAVING COALESCE(NULLIF(file.replication_factor, 0), $3) = 0
+            OR count(node.id) < COALESCE(NULLIF(file.replication_factor, 0), $3);
+    `
+
+	files, err := pg.getUnderReplicatedFiles(ctx, getUnderReplicatedFileSQL, nodeID, replicationFactor, uuid)
+	if err != nil {
+		return
+	}
+	if len(files) == 0 {
+		file.notExist = true
+		return
+	}
+	return files[0], nil
+}
+
+func (pg *Postgres) getUnderReplicatedFiles(ctx context.Context, query string, nodeID int64, replicationFactor int64, args ...any) (files []ReplicatedFile, err error) {
+	args = append([]any{nodeID, FileStateReady, replicationFactor, NodeStateActive}, args...)
+	rows, err := pg.pool.Query(ctx, query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		file := ReplicatedFile{}
+		err = scanFile(rows, &file.File, &file.Holders)
+		if err != nil {
+			return
+		}
+		files = append(files, file)
+	}
+	err = rows.Err()
+	return
+}
+
+// Channel notified with uuid of file when it becomes ready
+const FileReadyChannel = "file_ready"
+
+func (pg *Postgres) NotifyFileReady(ctx context.Context, uuid string) error {
+	const notifyFileReadySQL = `SELECT pg_notify($1, $2)`
+
+	_, err := pg.pool.Exec(ctx, notifyFileReadySQL, FileReadyChannel, uuid)
+	return err
+}
+
+// Returns deleted files which are still present on node
+func (pg *Postgres) GetDeletedFilesOnNode(ctx context.Context, nodeID int64) (files []File, err error) {
+	const getDeletedFilesOnNodeSQL = `
+        SELECT ` + fileColumns + `
+        FROM file
+            JOIN node_file ON file.id=node_file.file_id
+        WHERE node_file.node_id=$1 AND file.state=$2;
     `
 
-	rows, err := pg.pool.Query(ctx, getNotSyncedFilesSQL, nodeID)
+	rows, err := pg.pool.Query(ctx, getDeletedFilesOnNodeSQL, nodeID, FileStateDeleted)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 	for rows.Next() {
 		file := File{}
-		err = rows.Scan(&file.ID, &file.UUID, &file.State, &file.Size, &file.Created_at)
+		err = scanFile(rows, &file)
 		if err != nil {
 			return
 		}
 		files = append(files, file)
 	}
+	err = rows.Err()
+	return
+}
+
+func (pg *Postgres) GetFileByUUID(ctx context.Context, uuid string) (file File, err error) {
+	const getFileByUUIDSQL = `
+        SELECT ` + fileColumns + `
+        FROM file
+        WHERE uuid=$1
+    `
+
+	err = scanFile(pg.pool.QueryRow(ctx, getFileByUUIDSQL, uuid), &file)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = nil
+		file.notExist = true
+	}
 	return
 }
 
 func (pg *Postgres) GetFileByUUIDAndState(ctx context.Context, uuid string, state int64) (file File, err error) {
 	const getFileByUUIDAndStateSQL = `
-        SELECT id, uuid, state, size, created_at
-        FROM file 
+        SELECT ` + fileColumns + `
+        FROM file
         WHERE uuid=$1 and state=$2
     `
 
-	err = pg.pool.QueryRow(ctx, getFileByUUIDAndStateSQL, uuid, state).
-		Scan(
-			&file.ID,
-			&file.UUID,
-			&file.State,
-			&file.Size,
-			&file.Created_at,
-		)
+	err = scanFile(pg.pool.QueryRow(ctx, getFileByUUIDAndStateSQL, uuid, state), &file)
 	if errors.Is(err, pgx.ErrNoRows) {
 		err = nil
 		file.notExist = true
 	}
 	return
 }
+
+// Filter of ListFiles, nil fields aren't checked
+type FileFilter struct {
+	State        *int64
+	MinSize      *int64
+	MaxSize      *int64
+	MinCreatedAt *int64
+	MaxCreatedAt *int64
+	NamespaceID  *int64
+	// prefix of key
+	Prefix string
+	// files must have all tags
+	Tags map[string]string
+}
+
+// File with names of its namespace and nodes where it's present
+type ListedFile struct {
+	File
+	Namespace string
+	Nodes     []string
+}
+
+// Returns files with id greater than after ordered by id
+func (pg *Postgres) ListFiles(ctx context.Context, filter FileFilter, after int64, limit int64) (files []ListedFile, err error) {
+	const listFilesSQL = `
+        SELECT ` + fileColumns + `, COALESCE(namespace.name, ''),
+            COALESCE(array_agg(node.name ORDER BY node.name) FILTER (WHERE node.id IS NOT NULL), '{}')
+        FROM file
+            LEFT JOIN namespace ON file.namespace_id=namespace.id
+            LEFT JOIN node_file ON file.id=node_file.file_id
+            LEFT JOIN node ON node_file.node_id=node.id
+        WHERE file.id>$1 %s
+        GROUP BY file.id, namespace.id
+        ORDER BY file.id
+        LIMIT $2;
+    `
+
+	args := []any{after, limit}
+	conditions := ""
+	addCondition := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions += fmt.Sprintf(" AND "+condition, len(args))
+	}
+	if filter.State != nil {
+		addCondition("file.state=$%d", *filter.State)
+	}
+	if filter.MinSize != nil {
+		addCondition("file.size>=$%d", *filter.MinSize)
+	}
+	if filter.MaxSize != nil {
+		addCondition("file.size<=$%d", *filter.MaxSize)
+	}
+	if filter.MinCreatedAt != nil {
+		addCondition("file.created_at>=$%d", *filter.MinCreatedAt)
+	}
+	if filter.MaxCreatedAt != nil {
+		addCondition("file.created_at<=$%d", *filter.MaxCreatedAt)
+	}
+	if filter.NamespaceID != nil {
+		addCondition("file.namespace_id=$%d", *filter.NamespaceID)
+	}
+	if filter.Prefix != "" {
+		addCondition("starts_with(file.key, $%d)", filter.Prefix)
+	}
+	if len(filter.Tags) > 0 {
+		addCondition("file.tags @> $%d::jsonb", filter.Tags)
+	}
+
+	rows, err := pg.pool.Query(ctx, fmt.Sprintf(listFilesSQL, conditions), args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		file := ListedFile{}
+		err = scanFile(rows, &file.File, &file.Namespace, &file.Nodes)
+		if err != nil {
+			return
+		}
+		files = append(files, file)
+	}
+	err = rows.Err()
+	return
+}
@@ -0,0 +1,69 @@
+package postgres
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	poolAcquiredConnsDesc = prometheus.NewDesc(
+		"bronze_pheasant_pgxpool_acquired_conns",
+		"Number of connections currently in use",
+		nil, nil,
+	)
+	poolIdleConnsDesc = prometheus.NewDesc(
+		"bronze_pheasant_pgxpool_idle_conns",
+		"Number of idle connections",
+		nil, nil,
+	)
+	poolTotalConnsDesc = prometheus.NewDesc(
+		"bronze_pheasant_pgxpool_total_conns",
+		"Number of connections in pool",
+		nil, nil,
+	)
+	poolMaxConnsDesc = prometheus.NewDesc(
+		"bronze_pheasant_pgxpool_max_conns",
+		"Max size of pool",
+		nil, nil,
+	)
+	poolAcquiresDesc = prometheus.NewDesc(
+		"bronze_pheasant_pgxpool_acquires_total",
+		"Number of successful acquires of connection",
+		nil, nil,
+	)
+	poolEmptyAcquiresDesc = prometheus.NewDesc(
+		"bronze_pheasant_pgxpool_empty_acquires_total",
+		"Number of acquires which waited for connection",
+		nil, nil,
+	)
+	poolAcquireDurationDesc = prometheus.NewDesc(
+		"bronze_pheasant_pgxpool_acquire_duration_seconds_total",
+		"Total time spent to acquire connections",
+		nil, nil,
+	)
+)
+
+// Collects stats of connection pool on scrape
+type poolCollector struct {
+	pg *Postgres
+}
+
+func (c poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAcquiredConnsDesc
+	ch <- poolIdleConnsDesc
+	ch <- poolTotalConnsDesc
+	ch <- poolMaxConnsDesc
+	ch <- poolAcquiresDesc
+	ch <- poolEmptyAcquiresDesc
+	ch <- poolAcquireDurationDesc
+}
+
+func (c poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pg.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(poolAcquiredConnsDesc, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleConnsDesc, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotalConnsDesc, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMaxConnsDesc, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquiresDesc, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquiresDesc, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireDurationDesc, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
@@ -0,0 +1,184 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	locklib "github.com/muskelo/bronze-pheasant/lib/lock"
+)
+
+// Multipart upload of object, parts are stored in part files on node which started upload
+type MultipartUpload struct {
+	ID          int64
+	UUID        string
+	NamespaceID int64
+	Key         string
+	NodeID      int64
+	Created_at  int64
+	// unfinished upload is removed after expiration
+	ExpiresAt int64
+	// metadata of object created by upload
+	Metadata FileMetadata
+	notExist bool
+}
+
+func (upload MultipartUpload) IsExist() bool {
+	return !upload.notExist
+}
+
+func (upload MultipartUpload) IsExpired() bool {
+	return upload.ExpiresAt <= time.Now().Unix()
+}
+
+type MultipartPart struct {
+	Number int64
+	Size   int64
+	SHA256 string
+}
+
+const multipartUploadColumns = `multipart_upload.id, multipart_upload.uuid, multipart_upload.namespace_id,
+        multipart_upload.key, multipart_upload.node_id, multipart_upload.created_at, multipart_upload.expires_at,
+        multipart_upload.filename, multipart_upload.content_type, multipart_upload.tags`
+
+func scanMultipartUpload(row pgx.Row, upload *MultipartUpload) error {
+	return row.Scan(
+		&upload.ID,
+		&upload.UUID,
+		&upload.NamespaceID,
+		&upload.Key,
+		&upload.NodeID,
+		&upload.Created_at,
+		&upload.ExpiresAt,
+		&upload.Metadata.Filename,
+		&upload.Metadata.ContentType,
+		&upload.Metadata.Tags,
+	)
+}
+
+// Creates multipart upload which expires after lifetime
+func (pg *Postgres) CreateMultipartUpload(ctx context.Context, uuid string, namespaceID int64, key string, nodeID int64, metadata FileMetadata, lifetime time.Duration) (upload MultipartUpload, err error) {
+	const createMultipartUploadSQL = `
+        INSERT INTO multipart_upload
+        (uuid, namespace_id, key, node_id, created_at, expires_at, filename, content_type, tags)
+        SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9
+        WHERE $10::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$10 AND node.epoch=$11 FOR SHARE)
+        RETURNING ` + multipartUploadColumns + `;
+    `
+
+	fenceNodeID, epoch := pg.fence()
+	now := time.Now().Unix()
+	err = scanMultipartUpload(pg.pool.QueryRow(ctx, createMultipartUploadSQL, uuid, namespaceID, key, nodeID, now, now+int64(lifetime.Seconds()),
+		metadata.Filename, metadata.ContentType, metadata.tags(), fenceNodeID, epoch), &upload)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = locklib.ErrLockExpired
+	}
+	return
+}
+
+func (pg *Postgres) GetMultipartUpload(ctx context.Context, uuid string) (upload MultipartUpload, err error) {
+	const getMultipartUploadSQL = `
+        SELECT ` + multipartUploadColumns + `
+        FROM multipart_upload
+        WHERE uuid=$1
+    `
+
+	err = scanMultipartUpload(pg.pool.QueryRow(ctx, getMultipartUploadSQL, uuid), &upload)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = nil
+		upload.notExist = true
+	}
+	return
+}
+
+// Returns expired multipart uploads stored on node
+func (pg *Postgres) GetExpiredMultipartUploads(ctx context.Context, nodeID int64) (uploads []MultipartUpload, err error) {
+	const getExpiredMultipartUploadsSQL = `
+        SELECT ` + multipartUploadColumns + `
+        FROM multipart_upload
+        WHERE node_id=$1 AND expires_at<=$2;
+    `
+
+	rows, err := pg.pool.Query(ctx, getExpiredMultipartUploadsSQL, nodeID, time.Now().Unix())
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		upload := MultipartUpload{}
+		err = scanMultipartUpload(rows, &upload)
+		if err != nil {
+			return
+		}
+		uploads = append(uploads, upload)
+	}
+	err = rows.Err()
+	return
+}
+
+// Saves part of upload, part uploaded again replaces previous one
+func (pg *Postgres) PutMultipartPart(ctx context.Context, uploadID int64, part MultipartPart) error {
+	const putMultipartPartSQL = `
+        INSERT INTO multipart_part
+        (upload_id, number, size, sha256)
+        SELECT $1, $2, $3, $4
+        WHERE $5::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$5 AND node.epoch=$6 FOR SHARE)
+        ON CONFLICT (upload_id, number) DO UPDATE SET size=EXCLUDED.size, sha256=EXCLUDED.sha256;
+    `
+
+	fenceNodeID, epoch := pg.fence()
+	commandTag, err := pg.pool.Exec(ctx, putMultipartPartSQL, uploadID, part.Number, part.Size, part.SHA256, fenceNodeID, epoch)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return locklib.ErrLockExpired
+	}
+	return nil
+}
+
+// Returns parts of upload ordered by number
+func (pg *Postgres) GetMultipartParts(ctx context.Context, uploadID int64) (parts []MultipartPart, err error) {
+	const getMultipartPartsSQL = `
+        SELECT number, size, sha256
+        FROM multipart_part
+        WHERE upload_id=$1
+        ORDER BY number;
+    `
+
+	rows, err := pg.pool.Query(ctx, getMultipartPartsSQL, uploadID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		part := MultipartPart{}
+		err = rows.Scan(&part.Number, &part.Size, &part.SHA256)
+		if err != nil {
+			return
+		}
+		parts = append(parts, part)
+	}
+	err = rows.Err()
+	return
+}
+
+// Deletes upload with its parts
+func (pg *Postgres) DeleteMultipartUpload(ctx context.Context, id int64) error {
+	const deleteMultipartUploadSQL = `
+        DELETE FROM multipart_upload
+        WHERE id=$1
+            AND ($2::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$2 AND node.epoch=$3 FOR SHARE));
+    `
+
+	fenceNodeID, epoch := pg.fence()
+	commandTag, err := pg.pool.Exec(ctx, deleteMultipartUploadSQL, id, fenceNodeID, epoch)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return pg.checkFence(ctx, fenceNodeID, epoch)
+	}
+	return nil
+}
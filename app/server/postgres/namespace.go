@@ -0,0 +1,142 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	locklib "github.com/muskelo/bronze-pheasant/lib/lock"
+)
+
+// Namespace groups files addressed by key, it's a bucket in s3 api
+type Namespace struct {
+	ID         int64
+	Name       string
+	Created_at int64
+	notExist   bool
+}
+
+func (namespace Namespace) IsExist() bool {
+	return !namespace.notExist
+}
+
+var (
+	ErrNamespaceExist    = errors.New("Namespace already exist")
+	ErrNamespaceNotEmpty = errors.New("Namespace isn't empty")
+)
+
+const namespaceColumns = `namespace.id, namespace.name, namespace.created_at`
+
+func scanNamespace(row pgx.Row, namespace *Namespace) error {
+	return row.Scan(
+		&namespace.ID,
+		&namespace.Name,
+		&namespace.Created_at,
+	)
+}
+
+// Creates namespace, returns ErrNamespaceExist if name is taken
+func (pg *Postgres) CreateNamespace(ctx context.Context, name string) (namespace Namespace, err error) {
+	const createNamespaceSQL = `
+        INSERT INTO namespace
+        (name, created_at)
+        SELECT $1, $2
+        WHERE ($3::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$3 AND node.epoch=$4 FOR SHARE))
+        RETURNING ` + namespaceColumns + `;
+    `
+
+	fenceNodeID, epoch := pg.fence()
+	err = scanNamespace(pg.pool.QueryRow(ctx, createNamespaceSQL, name, time.Now().Unix(), fenceNodeID, epoch), &namespace)
+	pgErr := &pgconn.PgError{}
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		err = ErrNamespaceExist
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = locklib.ErrLockExpired
+	}
+	return
+}
+
+func (pg *Postgres) GetNamespaceByName(ctx context.Context, name string) (namespace Namespace, err error) {
+	const getNamespaceByNameSQL = `
+        SELECT ` + namespaceColumns + `
+        FROM namespace
+        WHERE name=$1
+    `
+
+	err = scanNamespace(pg.pool.QueryRow(ctx, getNamespaceByNameSQL, name), &namespace)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = nil
+		namespace.notExist = true
+	}
+	return
+}
+
+func (pg *Postgres) GetNamespaceByID(ctx context.Context, id int64) (namespace Namespace, err error) {
+	const getNamespaceByIDSQL = `
+        SELECT ` + namespaceColumns + `
+        FROM namespace
+        WHERE id=$1
+    `
+
+	err = scanNamespace(pg.pool.QueryRow(ctx, getNamespaceByIDSQL, id), &namespace)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = nil
+		namespace.notExist = true
+	}
+	return
+}
+
+func (pg *Postgres) GetNamespaces(ctx context.Context) (namespaces []Namespace, err error) {
+	const getNamespacesSQL = `
+        SELECT ` + namespaceColumns + `
+        FROM namespace
+        ORDER BY name;
+    `
+
+	rows, err := pg.pool.Query(ctx, getNamespacesSQL)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		namespace := Namespace{}
+		err = scanNamespace(rows, &namespace)
+		if err != nil {
+			return
+		}
+		namespaces = append(namespaces, namespace)
+	}
+	err = rows.Err()
+	return
+}
+
+// Deletes namespace without ready and new files, returns ErrNamespaceNotEmpty otherwise.
+// Deleted files keep data until sync managers remove it, their namespace becomes null.
+func (pg *Postgres) DeleteNamespace(ctx context.Context, id int64) error {
+	const deleteNamespaceSQL = `
+        DELETE FROM namespace
+        WHERE id=$1 AND NOT EXISTS (
+                SELECT 1
+                FROM file
+                WHERE file.namespace_id=$1 AND file.state<>$2
+            )
+            AND ($3::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$3 AND node.epoch=$4 FOR SHARE));
+    `
+
+	fenceNodeID, epoch := pg.fence()
+	commandTag, err := pg.pool.Exec(ctx, deleteNamespaceSQL, id, FileStateDeleted, fenceNodeID, epoch)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		err = pg.checkFence(ctx, fenceNodeID, epoch)
+		if err != nil {
+			return err
+		}
+		return ErrNamespaceNotEmpty
+	}
+	return nil
+}
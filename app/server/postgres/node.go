@@ -4,43 +4,71 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/jackc/pgx/v5"
-	// locklib "github.com/muskelo/bronze-pheasant/lib/lock"
+	"github.com/jackc/pgx/v5/pgconn"
+	locklib "github.com/muskelo/bronze-pheasant/lib/lock"
+)
+
+// Node states
+const (
+	NodeStateActive         = int64(0)
+	NodeStateDraining       = int64(1)
+	NodeStateDecommissioned = int64(2)
 )
 
 type Node struct {
 	ID            int64
 	Name          string
 	AdvertiseAddr string
-	Lock          int64
-	notExist      bool
+	// Lease of node lock, it's computed with time of postgres so clocks of nodes don't matter
+	LeaseExpiresAt time.Time
+	// Node holds unexpired lease
+	Alive bool
+	State int64
+	// Disk capacity and used bytes reported by node
+	Capacity int64
+	Used     int64
+	notExist bool
 }
 
 func (node Node) IsExist() bool {
 	return !node.notExist
 }
 
+// Columns expected by scanNode, additional columns can follow them
+const nodeColumns = `node.id, node.name, node.advertise_addr, node.lease_expires_at, node.lease_expires_at > now(), node.state,
+    node.capacity, node.used`
+
+func scanNode(row pgx.Row, node *Node, dest ...any) error {
+	return row.Scan(append([]any{
+		&node.ID,
+		&node.Name,
+		&node.AdvertiseAddr,
+		&node.LeaseExpiresAt,
+		&node.Alive,
+		&node.State,
+		&node.Capacity,
+		&node.Used,
+	}, dest...)...)
+}
+
 func (pg *Postgres) CreateNode(ctx context.Context, name string) (Node, error) {
 	const createNodeSQL = `
         INSERT INTO public.node
         ("name")
         VALUES($1)
-        RETURNING id, name, advertise_addr, lock;
+        RETURNING ` + nodeColumns + `;
     `
 
 	result := Node{}
-	err := pg.pool.QueryRow(ctx, createNodeSQL, name).Scan(
-		&result.ID,
-		&result.Name,
-		&result.AdvertiseAddr,
-		&result.Lock,
-	)
+	err := scanNode(pg.pool.QueryRow(ctx, createNodeSQL, name), &result)
 	return result, err
 }
 func (pg *Postgres) GetNodesWithinFile(ctx context.Context, id int64) ([]Node, error) {
 	const getNodesWithinFileSQL = `
-        SELECT node.id, node.name, node.advertise_addr, node.lock
+        SELECT ` + nodeColumns + `
         FROM node JOIN node_file ON node.id=node_file.node_id
         WHERE node_file.file_id=$1;
     `
@@ -53,12 +81,7 @@ func (pg *Postgres) GetNodesWithinFile(ctx context.Context, id int64) ([]Node, e
 	defer rows.Close()
 	for rows.Next() {
 		result := Node{}
-		err := rows.Scan(
-			&result.ID,
-			&result.Name,
-			&result.AdvertiseAddr,
-			&result.Lock,
-		)
+		err := scanNode(rows, &result)
 		if err != nil {
 			return results, err
 		}
@@ -67,50 +90,101 @@ func (pg *Postgres) GetNodesWithinFile(ctx context.Context, id int64) ([]Node, e
 	return results, nil
 }
 
-func (pg *Postgres) GetNodesWithinFileV2(ctx context.Context, fileUUID string, fileState int64, nodeLockNewer int64) (nodes []Node, err error) {
+// Returns alive nodes with copy of file in state fileState
+func (pg *Postgres) GetNodesWithinFileV2(ctx context.Context, fileUUID string, fileState int64) (nodes []Node, err error) {
 	const getNodesWithinFileSQL = `
-        SELECT node.id, node.name, node.advertise_addr, node.lock
-        FROM node 
+        SELECT ` + nodeColumns + `
+        FROM node
             JOIN node_file ON node.id=node_file.node_id
             JOIN file ON node_file.file_id=file.id
-        WHERE file."uuid"=$1 AND state=$2 AND node.lock > $3;
+        WHERE file."uuid"=$1 AND file.state=$2 AND node.lease_expires_at > now();
+    `
+
+	rows, err := pg.pool.Query(ctx, getNodesWithinFileSQL, fileUUID, fileState)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		node := Node{}
+		err = scanNode(rows, &node)
+		if err != nil {
+			return
+		}
+		nodes = append(nodes, node)
+	}
+	return
+}
+
+// Returns alive nodes in active state
+func (pg *Postgres) GetActiveNodes(ctx context.Context) (nodes []Node, err error) {
+	const getActiveNodesSQL = `
+        SELECT ` + nodeColumns + `
+        FROM node
+        WHERE lease_expires_at > now() AND state=$1;
     `
 
-	rows, err := pg.pool.Query(ctx, getNodesWithinFileSQL, fileUUID, fileState, nodeLockNewer)
+	rows, err := pg.pool.Query(ctx, getActiveNodesSQL, NodeStateActive)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 	for rows.Next() {
 		node := Node{}
-		err = rows.Scan(
-			&node.ID,
-			&node.Name,
-			&node.AdvertiseAddr,
-			&node.Lock,
-		)
+		err = scanNode(rows, &node)
 		if err != nil {
 			return
 		}
 		nodes = append(nodes, node)
 	}
+	err = rows.Err()
+	return
+}
+
+// Node with number and total size of copies registered on it
+type NodeStats struct {
+	Node
+	Files int64
+	Bytes int64
+}
+
+// Returns every node with its copies, decommissioned nodes included
+func (pg *Postgres) GetNodesStats(ctx context.Context) (nodes []NodeStats, err error) {
+	const getNodesStatsSQL = `
+        SELECT ` + nodeColumns + `, count(file.id), COALESCE(sum(file.size), 0)::int8
+        FROM node
+            LEFT JOIN node_file ON node.id=node_file.node_id
+            LEFT JOIN file ON file.id=node_file.file_id
+        GROUP BY node.id
+        ORDER BY node.name;
+    `
+
+	rows, err := pg.pool.Query(ctx, getNodesStatsSQL)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		node := NodeStats{}
+		err = scanNode(rows, &node.Node, &node.Files, &node.Bytes)
+		if err != nil {
+			return
+		}
+		nodes = append(nodes, node)
+	}
+	err = rows.Err()
 	return
 }
 
 func (pg *Postgres) GetNodeByName(ctx context.Context, name string) (Node, error) {
 	const getNodeByNameSQL = `
-        SELECT id, name, advertise_addr, lock 
-        FROM public.node 
+        SELECT ` + nodeColumns + `
+        FROM public.node
         WHERE name=$1
     `
 
 	result := Node{}
-	err := pg.pool.QueryRow(ctx, getNodeByNameSQL, name).Scan(
-		&result.ID,
-		&result.Name,
-		&result.AdvertiseAddr,
-		&result.Lock,
-	)
+	err := scanNode(pg.pool.QueryRow(ctx, getNodeByNameSQL, name), &result)
 	if errors.Is(err, pgx.ErrNoRows) {
 		result.notExist = true
 		err = nil
@@ -118,76 +192,424 @@ func (pg *Postgres) GetNodeByName(ctx context.Context, name string) (Node, error
 	return result, err
 }
 
+func (pg *Postgres) GetNodeByID(ctx context.Context, id int64) (Node, error) {
+	const getNodeByIDSQL = `
+        SELECT ` + nodeColumns + `
+        FROM public.node
+        WHERE id=$1
+    `
+
+	result := Node{}
+	err := scanNode(pg.pool.QueryRow(ctx, getNodeByIDSQL, id), &result)
+	if errors.Is(err, pgx.ErrNoRows) {
+		result.notExist = true
+		err = nil
+	}
+	return result, err
+}
+
+// Sets state of node if its current state is oldState
+func (pg *Postgres) UpdateNodeState(ctx context.Context, id int64, state int64, oldState int64) (node Node, err error) {
+	const updateNodeStateSQL = `
+        UPDATE node
+        SET state=$2
+        WHERE id=$1 AND state=$3
+            AND ($4::int8=0 OR EXISTS (SELECT 1 FROM node AS fence WHERE fence.id=$4 AND fence.epoch=$5 FOR SHARE))
+        RETURNING ` + nodeColumns + `;
+    `
+
+	fenceNodeID, epoch := pg.fence()
+	err = scanNode(pg.pool.QueryRow(ctx, updateNodeStateSQL, id, state, oldState, fenceNodeID, epoch), &node)
+	if errors.Is(err, pgx.ErrNoRows) {
+		node.notExist = true
+		err = pg.checkFence(ctx, fenceNodeID, epoch)
+	}
+	return
+}
+
+// Returns number of ready files on node which don't have enough copies on other active nodes,
+// at least one copy is required for files with replication factor 0
+func (pg *Postgres) CountFilesAtRisk(ctx context.Context, id int64, replicationFactor int64) (count int64, err error) {
+	const countFilesAtRiskSQL = `
+        SELECT count(*)
+        FROM file
+            JOIN node_file ON file.id=node_file.file_id
+        WHERE node_file.node_id=$1 AND file.state=$2 AND (
+                SELECT count(*)
+                FROM node_file AS other
+                    JOIN node ON other.node_id=node.id
+                WHERE other.file_id=file.id AND node.id<>$1 AND node.state=$3 AND node.lease_expires_at > now()
+            ) < GREATEST(COALESCE(NULLIF(file.replication_factor, 0), $4), 1);
+    `
+
+	err = pg.pool.QueryRow(ctx, countFilesAtRiskSQL, id, FileStateReady, NodeStateActive, replicationFactor).
+		Scan(&count)
+	return
+}
+
+// Moves draining node to decommissioned state and forgets about its files
+func (pg *Postgres) DecommissionNode(ctx context.Context, id int64) (node Node, err error) {
+	const decommissionNodeSQL = `
+        UPDATE node
+        SET state=$2
+        WHERE id=$1 AND state=$3
+            AND ($4::int8=0 OR EXISTS (SELECT 1 FROM node AS fence WHERE fence.id=$4 AND fence.epoch=$5 FOR SHARE))
+        RETURNING ` + nodeColumns + `;
+    `
+	const removeNodeFilesSQL = `
+        DELETE FROM node_file
+        WHERE node_id=$1;
+    `
+
+	fenceNodeID, epoch := pg.fence()
+	tx, err := pg.pool.Begin(ctx)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	err = scanNode(tx.QueryRow(ctx, decommissionNodeSQL, id, NodeStateDecommissioned, NodeStateDraining, fenceNodeID, epoch), &node)
+	if errors.Is(err, pgx.ErrNoRows) {
+		node.notExist = true
+		err = pg.checkFence(ctx, fenceNodeID, epoch)
+		return
+	}
+	if err != nil {
+		return
+	}
+	// row of node is locked by previous statement, fence holds until commit
+	_, err = tx.Exec(ctx, removeNodeFilesSQL, id)
+	if err != nil {
+		return
+	}
+	err = tx.Commit(ctx)
+	return
+}
+
 func (pg *Postgres) UpdateNodeAdvertiseAddr(ctx context.Context, nodeID int64, advertiseAddr string) error {
-	const updateNodeAdvertiseAddrSQL = `UPDATE public.node SET advertise_addr=$1 WHERE id=$2`
+	const updateNodeAdvertiseAddrSQL = `
+        UPDATE public.node SET advertise_addr=$1
+        WHERE id=$2 AND ($3::int8=0 OR EXISTS (SELECT 1 FROM node AS fence WHERE fence.id=$3 AND fence.epoch=$4 FOR SHARE))
+    `
 
-	// if !pg.lock.IsFresh() {
-	// 	return locklib.ErrLockExpired
-	// }
+	err := pg.checkLock()
+	if err != nil {
+		return err
+	}
 
-	commandTag, err := pg.pool.Exec(ctx, updateNodeAdvertiseAddrSQL, advertiseAddr, nodeID)
+	fenceNodeID, epoch := pg.fence()
+	commandTag, err := pg.pool.Exec(ctx, updateNodeAdvertiseAddrSQL, advertiseAddr, nodeID, fenceNodeID, epoch)
 	if err != nil {
 		return err
 	}
 	if commandTag.RowsAffected() != 1 {
+		err = pg.checkFence(ctx, fenceNodeID, epoch)
+		if err != nil {
+			return err
+		}
 		return fmt.Errorf("Advertise addr not updated (%v)\n", commandTag.RowsAffected())
 	}
 	return nil
 }
 
+func (pg *Postgres) UpdateNodeUsage(ctx context.Context, nodeID int64, used int64, capacity int64) error {
+	const updateNodeUsageSQL = `
+        UPDATE public.node SET used=$1, capacity=$2
+        WHERE id=$3 AND ($4::int8=0 OR EXISTS (SELECT 1 FROM node AS fence WHERE fence.id=$4 AND fence.epoch=$5 FOR SHARE))
+    `
+
+	fenceNodeID, epoch := pg.fence()
+	commandTag, err := pg.pool.Exec(ctx, updateNodeUsageSQL, used, capacity, nodeID, fenceNodeID, epoch)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return pg.checkFence(ctx, fenceNodeID, epoch)
+	}
+	return nil
+}
+
+// Returns up to limit ready files present on node with id greater than after ordered by id,
+// together with their holders
+func (pg *Postgres) GetNodeFiles(ctx context.Context, nodeID int64, after int64, limit int64) (files []ReplicatedFile, err error) {
+	const getNodeFilesSQL = `
+        SELECT ` + fileColumns + `, array_agg(holder.node_id)
+        FROM file
+            JOIN node_file ON file.id=node_file.file_id
+            JOIN node_file AS holder ON file.id=holder.file_id
+        WHERE node_file.node_id=$1 AND file.state=$2 AND file.id>$3
+        GROUP BY file.id
+        ORDER BY file.id
+        LIMIT $4;
+    `
+
+	rows, err := pg.pool.Query(ctx, getNodeFilesSQL, nodeID, FileStateReady, after, limit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		file := ReplicatedFile{}
+		err = scanFile(rows, &file.File, &file.Holders)
+		if err != nil {
+			return
+		}
+		files = append(files, file)
+	}
+	err = rows.Err()
+	return
+}
+
+// Moves copy of file from node to queue of removal, node removes it from disk later.
+// Returns false if node doesn't have the file.
+func (pg *Postgres) MoveFileFromNode(ctx context.Context, nodeID int64, fileID int64) (bool, error) {
+	const lockFileSQL = `
+        SELECT 1
+        FROM file
+        WHERE id=$1
+        FOR UPDATE;
+    `
+	const removeFileFromNodeSQL = `
+        DELETE FROM node_file
+        WHERE node_id=$1 AND file_id=$2
+            AND ($3::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$3 AND node.epoch=$4 FOR SHARE));
+    `
+	const addFileRemovalSQL = `
+        INSERT INTO node_file_removal
+        (node_id, file_id)
+        VALUES($1, $2)
+        ON CONFLICT DO NOTHING;
+    `
+
+	fenceNodeID, epoch := pg.fence()
+	tx, err := pg.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	// moves and trims of the same file are serialized, so they can't remove too many copies
+	_, err = tx.Exec(ctx, lockFileSQL, fileID)
+	if err != nil {
+		return false, err
+	}
+	commandTag, err := tx.Exec(ctx, removeFileFromNodeSQL, nodeID, fileID, fenceNodeID, epoch)
+	if err != nil {
+		return false, err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return false, pg.checkFence(ctx, fenceNodeID, epoch)
+	}
+	// row of node is locked by previous statement, fence holds until commit
+	_, err = tx.Exec(ctx, addFileRemovalSQL, nodeID, fileID)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+// Removes copy of file from node the same way as MoveFileFromNode if file has more copies than
+// its replication factor, replicationFactor of cluster is used if file doesn't have own one.
+// Returns false if copy is needed.
+func (pg *Postgres) TrimFileCopy(ctx context.Context, nodeID int64, fileID int64, replicationFactor int64) (bool, error) {
+	const lockFileSQL = `
+        SELECT replication_factor
+        FROM file
+        WHERE id=$1
+        FOR UPDATE;
+    `
+	const countCopiesSQL = `
+        SELECT count(*)
+        FROM node_file
+        WHERE file_id=$1;
+    `
+	const removeFileFromNodeSQL = `
+        DELETE FROM node_file
+        WHERE node_id=$1 AND file_id=$2
+            AND ($3::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$3 AND node.epoch=$4 FOR SHARE));
+    `
+	const addFileRemovalSQL = `
+        INSERT INTO node_file_removal
+        (node_id, file_id)
+        VALUES($1, $2)
+        ON CONFLICT DO NOTHING;
+    `
+
+	fenceNodeID, epoch := pg.fence()
+	tx, err := pg.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	fileReplicationFactor := int64(0)
+	err = tx.QueryRow(ctx, lockFileSQL, fileID).Scan(&fileReplicationFactor)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if fileReplicationFactor > 0 {
+		replicationFactor = fileReplicationFactor
+	}
+	copies := int64(0)
+	err = tx.QueryRow(ctx, countCopiesSQL, fileID).Scan(&copies)
+	if err != nil {
+		return false, err
+	}
+	// 0 means file must be present on every node
+	if replicationFactor == 0 || copies <= replicationFactor {
+		return false, nil
+	}
+	commandTag, err := tx.Exec(ctx, removeFileFromNodeSQL, nodeID, fileID, fenceNodeID, epoch)
+	if err != nil {
+		return false, err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return false, pg.checkFence(ctx, fenceNodeID, epoch)
+	}
+	// row of node is locked by previous statement, fence holds until commit
+	_, err = tx.Exec(ctx, addFileRemovalSQL, nodeID, fileID)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+// Returns files queued for removal from node
+func (pg *Postgres) GetFileRemovals(ctx context.Context, nodeID int64) (files []File, err error) {
+	const getFileRemovalsSQL = `
+        SELECT ` + fileColumns + `
+        FROM file
+            JOIN node_file_removal ON file.id=node_file_removal.file_id
+        WHERE node_file_removal.node_id=$1;
+    `
+
+	rows, err := pg.pool.Query(ctx, getFileRemovalsSQL, nodeID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		file := File{}
+		err = scanFile(rows, &file)
+		if err != nil {
+			return
+		}
+		files = append(files, file)
+	}
+	err = rows.Err()
+	return
+}
+
+func (pg *Postgres) FinishFileRemoval(ctx context.Context, nodeID int64, fileID int64) error {
+	const finishFileRemovalSQL = `
+        DELETE FROM node_file_removal
+        WHERE node_id=$1 AND file_id=$2
+            AND ($3::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$3 AND node.epoch=$4 FOR SHARE));
+    `
+
+	fenceNodeID, epoch := pg.fence()
+	commandTag, err := pg.pool.Exec(ctx, finishFileRemovalSQL, nodeID, fileID, fenceNodeID, epoch)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return pg.checkFence(ctx, fenceNodeID, epoch)
+	}
+	return nil
+}
+
 func (pg *Postgres) AddFileToNode(ctx context.Context, nodeID int64, fileID int64) error {
 	const addFileToNodeSQL = `
         INSERT INTO public.node_file
         (node_id, file_id)
-        VALUES($1, $2);
+        SELECT $1, $2
+        WHERE $3::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$3 AND node.epoch=$4 FOR SHARE);
     `
 
-	// if !pg.lock.IsFresh() {
-	// 	return locklib.ErrLockExpired
-	// }
-	_, err := pg.pool.Exec(ctx, addFileToNodeSQL, nodeID, fileID)
-	return err
+	err := pg.checkLock()
+	if err != nil {
+		return err
+	}
+	fenceNodeID, epoch := pg.fence()
+	commandTag, err := pg.pool.Exec(ctx, addFileToNodeSQL, nodeID, fileID, fenceNodeID, epoch)
+	pgErr := &pgconn.PgError{}
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		return ErrFileNotExist
+	}
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return locklib.ErrLockExpired
+	}
+	return nil
 }
 
-// Sets a lock to `lock` on node with id `id` and lock lower that `lockLower`
-func (pg *Postgres) TakeNodeLock(ctx context.Context, lock int64, id int64, lockLower int64) (int64, error) {
-	const initNodeLockSQL = `
+func (pg *Postgres) RemoveFileFromNode(ctx context.Context, nodeID int64, fileID int64) error {
+	const removeFileFromNodeSQL = `
+        DELETE FROM public.node_file
+        WHERE node_id=$1 AND file_id=$2
+            AND ($3::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$3 AND node.epoch=$4 FOR SHARE));
+    `
+
+	fenceNodeID, epoch := pg.fence()
+	commandTag, err := pg.pool.Exec(ctx, removeFileFromNodeSQL, nodeID, fileID, fenceNodeID, epoch)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return pg.checkFence(ctx, fenceNodeID, epoch)
+	}
+	return nil
+}
+
+// Takes lease of node with id `id` if the previous one expired, lease expires after lifetime.
+// Returns new epoch of node which fences writes of previous holders of lock
+func (pg *Postgres) TakeNodeLock(ctx context.Context, id int64, lifetime time.Duration) (epoch int64, err error) {
+	const takeNodeLockSQL = `
         UPDATE node
-        SET lock=$1
-        WHERE id=$2 AND lock < $3
+        SET lease_expires_at=clock_timestamp() + make_interval(secs => $2), epoch=epoch+1
+        WHERE id=$1 AND lease_expires_at < clock_timestamp()
+        RETURNING epoch
     `
 
-	commandTag, err := pg.pool.Exec(ctx, initNodeLockSQL, lock, id, lockLower)
-	return commandTag.RowsAffected(), err
+	err = pg.pool.QueryRow(ctx, takeNodeLockSQL, id, lifetime.Seconds()).Scan(&epoch)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = fmt.Errorf("Failed take lock, it's held by another process")
+	}
+	return
 }
 
-// Set lock to `newLock` on node where id=`id` and lock=`oldLock`
-func (pg *Postgres) UpdateNodeLock(ctx context.Context, newLock int64, id int64, oldLock int64) error {
+// Extends lease of node where id=`id` and epoch=`epoch` by lifetime from now
+func (pg *Postgres) UpdateNodeLock(ctx context.Context, id int64, epoch int64, lifetime time.Duration) error {
 	const updateNodeLockSQL = `
         UPDATE node
-        SET lock=$1
-        WHERE id=$2 AND lock = $3
+        SET lease_expires_at=clock_timestamp() + make_interval(secs => $3)
+        WHERE id=$1 AND epoch=$2
     `
 
-	commandTag, err := pg.pool.Exec(ctx, updateNodeLockSQL, newLock, id, oldLock)
+	commandTag, err := pg.pool.Exec(ctx, updateNodeLockSQL, id, epoch, lifetime.Seconds())
 	if err != nil {
 		return err
 	}
 	if commandTag.RowsAffected() != 1 {
-		return fmt.Errorf("Failed init lock (%v)", commandTag.RowsAffected())
+		return fmt.Errorf("Failed update lock (%v)", commandTag.RowsAffected())
 	}
 	return nil
 }
 
-// Set lock to 0 on node where id=`id` and lock=`oldLock`
-func (pg *Postgres) ReleaseNodeLock(ctx context.Context, id int64, oldLock int64) error {
+// Expires lease of node where id=`id` and epoch=`epoch`
+func (pg *Postgres) ReleaseNodeLock(ctx context.Context, id int64, epoch int64) error {
 	const releaseNodeLockSQL = `
         UPDATE node
-        SET lock=0
-        WHERE id=$1 AND lock = $2
+        SET lease_expires_at=clock_timestamp()
+        WHERE id=$1 AND epoch=$2
     `
 
-	commandTag, err := pg.pool.Exec(ctx, releaseNodeLockSQL, id, oldLock)
+	commandTag, err := pg.pool.Exec(ctx, releaseNodeLockSQL, id, epoch)
 	if err != nil {
 		return err
 	}
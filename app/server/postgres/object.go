@@ -0,0 +1,155 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	locklib "github.com/muskelo/bronze-pheasant/lib/lock"
+)
+
+// Objects are files addressed by namespace and key. Every write creates new file with
+// own uuid, previous version with the same key is deleted when new one becomes ready.
+
+func (pg *Postgres) CreateObject(ctx context.Context, uuid string, namespaceID int64, key string, replicationFactor int64, metadata FileMetadata) (file File, err error) {
+	const createObjectSQL = `
+        INSERT INTO file
+        (uuid, state, size, created_at, replication_factor, namespace_id, key, filename, content_type, tags)
+        SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+        WHERE $11::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$11 AND node.epoch=$12 FOR SHARE)
+        RETURNING ` + fileColumns + `;
+    `
+
+	err = pg.checkLock()
+	if err != nil {
+		return
+	}
+	nodeID, epoch := pg.fence()
+	err = scanFile(pg.pool.QueryRow(ctx, createObjectSQL, uuid, FileStateNew, 0, time.Now().Unix(), replicationFactor, namespaceID, key,
+		metadata.Filename, metadata.ContentType, metadata.tags(), nodeID, epoch), &file)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = locklib.ErrLockExpired
+	}
+	return
+}
+
+// Marks object ready and deletes previous ready version with the same key. Commits of the
+// same key are serialized, so the last committed version wins.
+func (pg *Postgres) CommitObject(ctx context.Context, id int64, size int64, sha256 string) (file File, err error) {
+	const lockKeySQL = `
+        SELECT pg_advisory_xact_lock(hashtextextended(namespace_id::text || '/' || key, 0))
+        FROM file
+        WHERE id=$1;
+    `
+	const deletePreviousSQL = `
+        UPDATE file
+        SET state=$2, deleted_at=$3
+        FROM file AS object
+        WHERE object.id=$1 AND file.namespace_id=object.namespace_id AND file.key=object.key
+            AND file.state=$4 AND file.id<>$1
+            AND ($5::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$5 AND node.epoch=$6 FOR SHARE));
+    `
+	const commitObjectSQL = `
+        UPDATE file
+        SET state=$2, size=$3, sha256=$4
+        WHERE id=$1 AND state=$5
+            AND ($6::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$6 AND node.epoch=$7 FOR SHARE))
+        RETURNING ` + fileColumns + `;
+    `
+
+	err = pg.checkLock()
+	if err != nil {
+		return
+	}
+	nodeID, epoch := pg.fence()
+	tx, err := pg.pool.Begin(ctx)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// the next statements see version committed by concurrent write after lock is taken
+	_, err = tx.Exec(ctx, lockKeySQL, id)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(ctx, deletePreviousSQL, id, FileStateDeleted, time.Now().Unix(), FileStateReady, nodeID, epoch)
+	if err != nil {
+		return
+	}
+	err = scanFile(tx.QueryRow(ctx, commitObjectSQL, id, FileStateReady, size, sha256, FileStateNew, nodeID, epoch), &file)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = pg.checkFence(ctx, nodeID, epoch)
+		file.notExist = true
+		return
+	}
+	if err != nil {
+		return
+	}
+	err = tx.Commit(ctx)
+	return
+}
+
+// Returns ready object
+func (pg *Postgres) GetObject(ctx context.Context, namespaceID int64, key string) (file File, err error) {
+	const getObjectSQL = `
+        SELECT ` + fileColumns + `
+        FROM file
+        WHERE namespace_id=$1 AND key=$2 AND state=$3
+    `
+
+	err = scanFile(pg.pool.QueryRow(ctx, getObjectSQL, namespaceID, key, FileStateReady), &file)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = nil
+		file.notExist = true
+	}
+	return
+}
+
+// Marks ready object as deleted, the data itself is removed by sync managers of nodes
+func (pg *Postgres) DeleteObject(ctx context.Context, namespaceID int64, key string) (file File, err error) {
+	const deleteObjectSQL = `
+        UPDATE file
+        SET state=$3, deleted_at=$4
+        WHERE namespace_id=$1 AND key=$2 AND state=$5
+            AND ($6::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$6 AND node.epoch=$7 FOR SHARE))
+        RETURNING ` + fileColumns + `;
+    `
+
+	nodeID, epoch := pg.fence()
+	err = scanFile(pg.pool.QueryRow(ctx, deleteObjectSQL, namespaceID, key, FileStateDeleted, time.Now().Unix(), FileStateReady,
+		nodeID, epoch), &file)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = pg.checkFence(ctx, nodeID, epoch)
+		file.notExist = true
+	}
+	return
+}
+
+// Returns ready objects with key starting with prefix and greater than after, ordered by key
+func (pg *Postgres) ListObjects(ctx context.Context, namespaceID int64, prefix string, after string, limit int64) (files []File, err error) {
+	const listObjectsSQL = `
+        SELECT ` + fileColumns + `
+        FROM file
+        WHERE namespace_id=$1 AND state=$2 AND starts_with(key, $3) AND key>$4
+        ORDER BY key
+        LIMIT $5;
+    `
+
+	rows, err := pg.pool.Query(ctx, listObjectsSQL, namespaceID, FileStateReady, prefix, after, limit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		file := File{}
+		err = scanFile(rows, &file)
+		if err != nil {
+			return
+		}
+		files = append(files, file)
+	}
+	err = rows.Err()
+	return
+}
@@ -5,12 +5,19 @@ import (
 	"time"
 
 	"github.com/alecthomas/kingpin/v2"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/muskelo/bronze-pheasant/app/server/log"
+	locklib "github.com/muskelo/bronze-pheasant/lib/lock"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type Postgres struct {
 	pool         *pgxpool.Pool
+	connstr      string
 	pingInterval time.Duration
+	// lock of node, writes of files are rejected if it isn't fresh
+	lock locklib.Lock
 }
 
 func (pg *Postgres) Init(ctx context.Context, connstr string, pingInterval time.Duration) error {
@@ -19,10 +26,23 @@ func (pg *Postgres) Init(ctx context.Context, connstr string, pingInterval time.
 		return err
 	}
 	pg.pool = pool
+	pg.connstr = connstr
 	pg.pingInterval = pingInterval
 	return nil
 }
 
+func (pg *Postgres) SetLock(lock locklib.Lock) {
+	pg.lock = lock
+}
+
+// Returns ErrLockExpired if node lost its lock, node is read-only then
+func (pg *Postgres) checkLock() error {
+	if pg.lock != nil && !pg.lock.IsFresh() {
+		return locklib.ErrLockExpired
+	}
+	return nil
+}
+
 func (pg *Postgres) Close() {
 	pg.pool.Close()
 }
@@ -31,13 +51,43 @@ func (pg *Postgres) Ping(ctx context.Context) error {
 	return pg.pool.Ping(ctx)
 }
 
+// Pings postgres until ctx is done, node keeps serving local files while postgres is unreachable
 func (pg *Postgres) PingLoop(ctx context.Context) error {
 	for {
 		err := pg.Ping(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.G("pgping").Errorf("Postgres is unreachable: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(pg.pingInterval):
+		}
+	}
+}
+
+// Listens channel on dedicated connection and sends payloads of notifications to c,
+// notifications are dropped if c isn't ready to receive them
+func (pg *Postgres) Listen(ctx context.Context, channel string, c chan<- string) error {
+	conn, err := pgx.Connect(ctx, pg.connstr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	_, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize())
+	if err != nil {
+		return err
+	}
+	for {
+		notification, err := conn.WaitForNotification(ctx)
 		if err != nil {
 			return err
 		}
-		time.Sleep(pg.pingInterval)
+		select {
+		case c <- notification.Payload:
+		default:
+		}
 	}
 }
 
@@ -53,5 +103,9 @@ var (
 )
 
 func Init(ctx context.Context) error {
-	return Default.Init(ctx, *postgresConnstr, *postgresPingInterval)
+	err := Default.Init(ctx, *postgresConnstr, *postgresPingInterval)
+	if err != nil {
+		return err
+	}
+	return prometheus.Register(poolCollector{pg: Default})
 }
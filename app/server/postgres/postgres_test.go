@@ -11,6 +11,18 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+func newTestPostgres(t *testing.T) *Postgres {
+	connstr := os.Getenv("POSTGRES_UNITTEST_URL")
+	if connstr == "" {
+		t.Skip("POSTGRES_UNITTEST_URL isn't set")
+	}
+	pgi := &Postgres{}
+	err := pgi.Init(context.Background(), connstr, time.Second)
+	require.NoError(t, err, "Must create new postgres interface")
+	t.Cleanup(pgi.Close)
+	return pgi
+}
+
 func TestPostgresInterface(t *testing.T) {
 	var pgi *Postgres
 
@@ -23,10 +35,7 @@ func TestPostgresInterface(t *testing.T) {
 
 	t.Log("Create new pgi")
 	{
-		var err error
-
-		pgi, err = New(context.Background(), os.Getenv("POSTGRES_UNITTEST_URL"))
-		require.NoError(t, err, "Must create new postgres interface")
+		pgi = newTestPostgres(t)
 	}
 
 	t.Log("Test Node methods")
@@ -34,7 +43,7 @@ func TestPostgresInterface(t *testing.T) {
 		testID := 0
 		t.Logf("\tTest %d:\tTest CreateNode", testID)
 		{
-            name := fmt.Sprintf("my-node-%v", testID)
+			name := fmt.Sprintf("my-node-%v", testID)
 			result, err := pgi.CreateNode(context.Background(), name)
 			require.NoError(t, err, "Must creat row if table")
 			require.Equal(t, name, result.Name, "Result must container original name")
@@ -44,7 +53,7 @@ func TestPostgresInterface(t *testing.T) {
 		testID++
 		t.Logf("\tTest %d:\tTest GetNodeByName", testID)
 		{
-            name := fmt.Sprintf("my-node-%v", testID)
+			name := fmt.Sprintf("my-node-%v", testID)
 			createResult, err := pgi.CreateNode(context.Background(), name)
 			require.NoError(t, err, "Must creat row if table node")
 
@@ -56,7 +65,7 @@ func TestPostgresInterface(t *testing.T) {
 		testID++
 		t.Logf("\tTest %d:\tTest UpdateNodeAdvertiseAddr", testID)
 		{
-            name := fmt.Sprintf("my-node-%v", testID)
+			name := fmt.Sprintf("my-node-%v", testID)
 			createResult, err := pgi.CreateNode(context.Background(), name)
 			require.NoError(t, err, "Must creat row if table node")
 
@@ -65,18 +74,19 @@ func TestPostgresInterface(t *testing.T) {
 		}
 
 		testID++
-		t.Logf("\tTest %d:\tTest InitNodeLock", testID)
-        {
-            name := fmt.Sprintf("my-node-%v", testID)
+		t.Logf("\tTest %d:\tTest TakeNodeLock", testID)
+		{
+			name := fmt.Sprintf("my-node-%v", testID)
 			createdNode, err := pgi.CreateNode(context.Background(), name)
 			require.NoError(t, err, "Must creat row if table node")
 
-            timeBeforeLock := time.Now().Unix()
-            updateNode, err := pgi.InitNodeLock(context.Background(), createdNode.ID)
+			epoch, err := pgi.TakeNodeLock(context.Background(), createdNode.ID, time.Minute)
 			require.NoError(t, err, "Must update row if table node")
-            require.GreaterOrEqual(t, updateNode.Lock, timeBeforeLock, "Lock must be grater")
-            require.GreaterOrEqual(t, updateNode.Lock, time.Now().Unix(), "Lock must be lower")
-        }
+			require.Greater(t, epoch, int64(0), "Epoch must grow")
+
+			_, err = pgi.TakeNodeLock(context.Background(), createdNode.ID, time.Minute)
+			require.Error(t, err, "Lock must be held")
+		}
 	}
 
 	t.Log("Test File methods")
@@ -88,7 +98,7 @@ func TestPostgresInterface(t *testing.T) {
 			uuid := uuidp.NewString()
 			size := int64(1000)
 
-			result, err := pgi.CreateFile(context.Background(), uuid, size)
+			result, err := pgi.CreateFile(context.Background(), uuid, size, 0, FileMetadata{})
 			require.NoError(t, err, "Must creat row if table file")
 			require.Equal(t, uuid, result.UUID, "Result must container original uuid")
 			require.Equal(t, size, result.Size, "Result must container original size")
@@ -100,7 +110,7 @@ func TestPostgresInterface(t *testing.T) {
 			uuid := uuidp.NewString()
 			size := int64(1000)
 
-			_, err := pgi.CreateFile(context.Background(), uuid, size)
+			_, err := pgi.CreateFile(context.Background(), uuid, size, 0, FileMetadata{})
 			require.NoError(t, err, "Must creat row if table file")
 
 			result, err := pgi.GetFileByUUID(context.Background(), uuid)
@@ -110,3 +120,68 @@ func TestPostgresInterface(t *testing.T) {
 		}
 	}
 }
+
+func TestTrimFileCopy(t *testing.T) {
+	ctx := context.Background()
+	pgi := newTestPostgres(t)
+
+	nodes := []Node{}
+	for i := 0; i < 3; i++ {
+		node, err := pgi.CreateNode(ctx, fmt.Sprintf("trim-node-%v", uuidp.NewString()))
+		require.NoError(t, err, "Must create node")
+		nodes = append(nodes, node)
+	}
+	file, err := pgi.CreateFile(ctx, uuidp.NewString(), 1000, 2, FileMetadata{})
+	require.NoError(t, err, "Must create file")
+	for _, node := range nodes {
+		require.NoError(t, pgi.AddFileToNode(ctx, node.ID, file.ID))
+	}
+
+	t.Log("Extra copy is dropped")
+	{
+		trimmed, err := pgi.TrimFileCopy(ctx, nodes[0].ID, file.ID, 0)
+		require.NoError(t, err)
+		require.True(t, trimmed, "File has 3 copies and replication factor 2")
+	}
+
+	t.Log("Needed copy is kept")
+	{
+		trimmed, err := pgi.TrimFileCopy(ctx, nodes[1].ID, file.ID, 0)
+		require.NoError(t, err)
+		require.False(t, trimmed, "File has 2 copies and replication factor 2")
+
+		copies, err := pgi.GetNodesWithinFile(ctx, file.ID)
+		require.NoError(t, err)
+		require.Len(t, copies, 2)
+	}
+}
+
+func TestGetUnderReplicatedFiles(t *testing.T) {
+	ctx := context.Background()
+	pgi := newTestPostgres(t)
+
+	node, err := pgi.CreateNode(ctx, fmt.Sprintf("under-replicated-node-%v", uuidp.NewString()))
+	require.NoError(t, err, "Must create node")
+	files := []File{}
+	for i := 0; i < 3; i++ {
+		file, err := pgi.CreateFile(ctx, uuidp.NewString(), 0, 1, FileMetadata{})
+		require.NoError(t, err, "Must create file")
+		file, err = pgi.UpdateFile(ctx, file.ID, FileStateReady, 1000, "")
+		require.NoError(t, err, "Must make file ready")
+		files = append(files, file)
+	}
+
+	t.Log("Files are paged by id")
+	{
+		first, err := pgi.GetUnderReplicatedFiles(ctx, node.ID, 0, files[0].ID-1, 2)
+		require.NoError(t, err)
+		require.Len(t, first, 2, "Page must be limited")
+		require.Equal(t, files[0].ID, first[0].ID)
+		require.Equal(t, files[1].ID, first[1].ID)
+
+		second, err := pgi.GetUnderReplicatedFiles(ctx, node.ID, 0, first[1].ID, 2)
+		require.NoError(t, err)
+		require.NotEmpty(t, second)
+		require.Equal(t, files[2].ID, second[0].ID, "Next page must start after cursor")
+	}
+}
@@ -0,0 +1,100 @@
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	locklib "github.com/muskelo/bronze-pheasant/lib/lock"
+)
+
+// Cluster wide settings
+const (
+	SettingReplicationFactor = "replication_factor"
+	SettingPresignSecret     = "presign_secret"
+)
+
+// Returns value of setting or def if setting isn't set
+func (pg *Postgres) GetSetting(ctx context.Context, name string, def string) (value string, err error) {
+	const getSettingSQL = `
+        SELECT value
+        FROM setting
+        WHERE name=$1
+    `
+
+	err = pg.pool.QueryRow(ctx, getSettingSQL, name).Scan(&value)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = nil
+		value = def
+	}
+	return
+}
+
+func (pg *Postgres) SetSetting(ctx context.Context, name string, value string) error {
+	const setSettingSQL = `
+        INSERT INTO setting
+        (name, value)
+        SELECT $1, $2
+        WHERE ($3::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$3 AND node.epoch=$4 FOR SHARE))
+        ON CONFLICT (name) DO UPDATE SET value=EXCLUDED.value;
+    `
+
+	fenceNodeID, epoch := pg.fence()
+	commandTag, err := pg.pool.Exec(ctx, setSettingSQL, name, value, fenceNodeID, epoch)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return locklib.ErrLockExpired
+	}
+	return nil
+}
+
+// Returns cluster replication factor, 0 means file must be present on every node
+func (pg *Postgres) GetReplicationFactor(ctx context.Context) (int64, error) {
+	value, err := pg.GetSetting(ctx, SettingReplicationFactor, "0")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+func (pg *Postgres) SetReplicationFactor(ctx context.Context, replicationFactor int64) error {
+	return pg.SetSetting(ctx, SettingReplicationFactor, strconv.FormatInt(replicationFactor, 10))
+}
+
+// Returns secret of presigned urls, first call in cluster generates it
+func (pg *Postgres) GetPresignSecret(ctx context.Context) (secret string, err error) {
+	const initSettingSQL = `
+        INSERT INTO setting
+        (name, value)
+        SELECT $1, $2
+        WHERE ($3::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$3 AND node.epoch=$4 FOR SHARE))
+        ON CONFLICT (name) DO NOTHING;
+    `
+
+	secret, err = pg.GetSetting(ctx, SettingPresignSecret, "")
+	if err != nil || secret != "" {
+		return
+	}
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return
+	}
+	// other node can generate secret at the same time, so secret is read again
+	fenceNodeID, epoch := pg.fence()
+	_, err = pg.pool.Exec(ctx, initSettingSQL, SettingPresignSecret, hex.EncodeToString(b), fenceNodeID, epoch)
+	if err != nil {
+		return
+	}
+	secret, err = pg.GetSetting(ctx, SettingPresignSecret, "")
+	if err == nil && secret == "" {
+		// insert is rejected by fence, urls must not be signed with empty key
+		err = locklib.ErrLockExpired
+	}
+	return
+}
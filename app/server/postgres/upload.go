@@ -0,0 +1,208 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	locklib "github.com/muskelo/bronze-pheasant/lib/lock"
+)
+
+// Resumable upload, data is stored in upload file on node until all bytes are received
+type Upload struct {
+	FileID     int64
+	FileUUID   string
+	NodeID     int64
+	Offset     int64
+	Length     int64
+	Created_at int64
+	// unfinished upload is removed after expiration
+	ExpiresAt int64
+	notExist  bool
+}
+
+func (upload Upload) IsExist() bool {
+	return !upload.notExist
+}
+
+func (upload Upload) IsComplete() bool {
+	return upload.Offset == upload.Length
+}
+
+func (upload Upload) IsExpired() bool {
+	return upload.ExpiresAt <= time.Now().Unix()
+}
+
+// Columns expected by scanUpload, requires join with file
+const uploadColumns = `upload.file_id, file.uuid, upload.node_id, upload."offset", upload.length, upload.created_at,
+        upload.expires_at`
+
+func scanUpload(row pgx.Row, upload *Upload) error {
+	return row.Scan(
+		&upload.FileID,
+		&upload.FileUUID,
+		&upload.NodeID,
+		&upload.Offset,
+		&upload.Length,
+		&upload.Created_at,
+		&upload.ExpiresAt,
+	)
+}
+
+// Creates upload which expires after lifetime
+func (pg *Postgres) CreateUpload(ctx context.Context, fileID int64, nodeID int64, length int64, lifetime time.Duration) (upload Upload, err error) {
+	const createUploadSQL = `
+        INSERT INTO upload
+        (file_id, node_id, length, created_at, expires_at)
+        SELECT $1, $2, $3, $4, $5
+        WHERE $6::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$6 AND node.epoch=$7 FOR SHARE)
+        RETURNING upload.file_id, (SELECT uuid FROM file WHERE id=upload.file_id),
+            upload.node_id, upload."offset", upload.length, upload.created_at, upload.expires_at;
+    `
+
+	fenceNodeID, epoch := pg.fence()
+	now := time.Now().Unix()
+	err = scanUpload(pg.pool.QueryRow(ctx, createUploadSQL, fileID, nodeID, length, now, now+int64(lifetime.Seconds()),
+		fenceNodeID, epoch), &upload)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = locklib.ErrLockExpired
+	}
+	return
+}
+
+func (pg *Postgres) GetUploadByUUID(ctx context.Context, uuid string) (upload Upload, err error) {
+	const getUploadByUUIDSQL = `
+        SELECT ` + uploadColumns + `
+        FROM upload JOIN file ON upload.file_id=file.id
+        WHERE file.uuid=$1;
+    `
+
+	err = scanUpload(pg.pool.QueryRow(ctx, getUploadByUUIDSQL, uuid), &upload)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = nil
+		upload.notExist = true
+	}
+	return
+}
+
+// Returns expired uploads stored on node
+func (pg *Postgres) GetExpiredUploads(ctx context.Context, nodeID int64) (uploads []Upload, err error) {
+	const getExpiredUploadsSQL = `
+        SELECT ` + uploadColumns + `
+        FROM upload JOIN file ON upload.file_id=file.id
+        WHERE upload.node_id=$1 AND upload.expires_at<=$2;
+    `
+
+	rows, err := pg.pool.Query(ctx, getExpiredUploadsSQL, nodeID, time.Now().Unix())
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		upload := Upload{}
+		err = scanUpload(rows, &upload)
+		if err != nil {
+			return
+		}
+		uploads = append(uploads, upload)
+	}
+	err = rows.Err()
+	return
+}
+
+// Sets offset of upload if its current offset is oldOffset
+func (pg *Postgres) UpdateUploadOffset(ctx context.Context, fileID int64, offset int64, oldOffset int64) error {
+	const updateUploadOffsetSQL = `
+        UPDATE upload
+        SET "offset"=$2
+        WHERE file_id=$1 AND "offset"=$3
+            AND ($4::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$4 AND node.epoch=$5 FOR SHARE));
+    `
+
+	fenceNodeID, epoch := pg.fence()
+	commandTag, err := pg.pool.Exec(ctx, updateUploadOffsetSQL, fileID, offset, oldOffset, fenceNodeID, epoch)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		err = pg.checkFence(ctx, fenceNodeID, epoch)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("Upload offset not updated (%v)", commandTag.RowsAffected())
+	}
+	return nil
+}
+
+func (pg *Postgres) DeleteUpload(ctx context.Context, fileID int64) error {
+	const deleteUploadSQL = `
+        DELETE FROM upload
+        WHERE file_id=$1
+            AND ($2::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$2 AND node.epoch=$3 FOR SHARE));
+    `
+
+	fenceNodeID, epoch := pg.fence()
+	commandTag, err := pg.pool.Exec(ctx, deleteUploadSQL, fileID, fenceNodeID, epoch)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return pg.checkFence(ctx, fenceNodeID, epoch)
+	}
+	return nil
+}
+
+// Marks file of complete upload ready, registers its copy on node and removes upload in one
+// transaction, so failed finish can be retried
+func (pg *Postgres) FinishUpload(ctx context.Context, fileID int64, nodeID int64, size int64, sha256 string) (file File, err error) {
+	const readyFileSQL = `
+        UPDATE file
+        SET state=$2, size=$3, sha256=$4
+        WHERE id=$1 AND state=$5
+            AND ($6::int8=0 OR EXISTS (SELECT 1 FROM node WHERE node.id=$6 AND node.epoch=$7 FOR SHARE))
+        RETURNING ` + fileColumns + `;
+    `
+	const addFileToNodeSQL = `
+        INSERT INTO node_file
+        (node_id, file_id)
+        VALUES($1, $2);
+    `
+	const deleteUploadSQL = `
+        DELETE FROM upload
+        WHERE file_id=$1;
+    `
+
+	err = pg.checkLock()
+	if err != nil {
+		return
+	}
+	fenceNodeID, epoch := pg.fence()
+	tx, err := pg.pool.Begin(ctx)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	err = scanFile(tx.QueryRow(ctx, readyFileSQL, fileID, FileStateReady, size, sha256, FileStateNew, fenceNodeID, epoch), &file)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = pg.checkFence(ctx, fenceNodeID, epoch)
+		file.notExist = true
+		return
+	}
+	if err != nil {
+		return
+	}
+	// row of node is locked by previous statement, fence holds until commit
+	_, err = tx.Exec(ctx, addFileToNodeSQL, nodeID, fileID)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(ctx, deleteUploadSQL, fileID)
+	if err != nil {
+		return
+	}
+	err = tx.Commit(ctx)
+	return
+}
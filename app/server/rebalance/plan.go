@@ -0,0 +1,102 @@
+package rebalance
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+)
+
+// Copy of file from one node to another, the source copy is removed after copying
+type Move struct {
+	FileUUID string        `json:"file_uuid"`
+	Size     int64         `json:"size"`
+	FromID   int64         `json:"from_id"`
+	From     string        `json:"from"`
+	ToID     int64         `json:"to_id"`
+	To       string        `json:"to"`
+	File     postgres.File `json:"-"`
+}
+
+func usageRatio(node postgres.Node, used int64) float64 {
+	return float64(used) / float64(node.Capacity)
+}
+
+// Plans moves from the most used nodes to the least used ones until difference of their usage
+// ratios is lower than threshold or maxBytes are planned. Nodes must have non zero capacity,
+// files contains movable files of every node by its id.
+func Plan(nodes []postgres.Node, files map[int64][]postgres.ReplicatedFile, threshold float64, maxBytes int64) []Move {
+	used := map[int64]int64{}
+	for _, node := range nodes {
+		used[node.ID] = node.Used
+	}
+	holders := map[int64][]int64{}
+	for _, nodeFiles := range files {
+		for _, file := range nodeFiles {
+			holders[file.ID] = file.Holders
+		}
+	}
+	moved := map[int64]bool{}
+
+	// returns first file of donor which can be moved to receiver
+	findFile := func(donor postgres.Node, receiver postgres.Node, budget int64) (postgres.ReplicatedFile, bool) {
+		for _, file := range files[donor.ID] {
+			if moved[file.ID] || file.Size > budget || slices.Contains(holders[file.ID], receiver.ID) {
+				continue
+			}
+			// receiver must stay less used than donor, otherwise file would be moved back
+			if usageRatio(receiver, used[receiver.ID]+file.Size) > usageRatio(donor, used[donor.ID]-file.Size) {
+				continue
+			}
+			return file, true
+		}
+		return postgres.ReplicatedFile{}, false
+	}
+
+	moves := []Move{}
+	budget := maxBytes
+	for {
+		ordered := slices.Clone(nodes)
+		slices.SortFunc(ordered, func(a, b postgres.Node) int {
+			c := cmp.Compare(usageRatio(b, used[b.ID]), usageRatio(a, used[a.ID]))
+			if c != 0 {
+				return c
+			}
+			return cmp.Compare(a.ID, b.ID)
+		})
+
+		// take the most unbalanced pair which has something to move
+		var move *Move
+		for i := 0; i < len(ordered) && move == nil; i++ {
+			donor := ordered[i]
+			for j := len(ordered) - 1; j > i && move == nil; j-- {
+				receiver := ordered[j]
+				if usageRatio(donor, used[donor.ID])-usageRatio(receiver, used[receiver.ID]) <= threshold {
+					break
+				}
+				file, ok := findFile(donor, receiver, budget)
+				if !ok {
+					continue
+				}
+				move = &Move{
+					FileUUID: file.UUID,
+					Size:     file.Size,
+					FromID:   donor.ID,
+					From:     donor.Name,
+					ToID:     receiver.ID,
+					To:       receiver.Name,
+					File:     file.File,
+				}
+			}
+		}
+		if move == nil {
+			return moves
+		}
+
+		moves = append(moves, *move)
+		moved[move.File.ID] = true
+		used[move.FromID] -= move.Size
+		used[move.ToID] += move.Size
+		budget -= move.Size
+	}
+}
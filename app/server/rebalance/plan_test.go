@@ -0,0 +1,64 @@
+package rebalance
+
+import (
+	"testing"
+
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlan(t *testing.T) {
+	nodes := []postgres.Node{
+		{ID: 1, Name: "full", Capacity: 1000, Used: 800},
+		{ID: 2, Name: "empty", Capacity: 1000, Used: 0},
+	}
+	files := map[int64][]postgres.ReplicatedFile{}
+	for i := int64(1); i <= 10; i++ {
+		files[1] = append(files[1], postgres.ReplicatedFile{
+			File:    postgres.File{ID: i, Size: 80},
+			Holders: []int64{1},
+		})
+	}
+
+	t.Log("Test Plan function")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tMoves even out usage", testID)
+		{
+			moves := Plan(nodes, files, 0.1, 1000)
+			require.Len(t, moves, 5, "Must move half of files")
+			for _, move := range moves {
+				require.Equal(t, int64(1), move.FromID, "Must move from the most used node")
+				require.Equal(t, int64(2), move.ToID, "Must move to the least used node")
+			}
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tMoves are limited by max bytes", testID)
+		{
+			moves := Plan(nodes, files, 0.1, 200)
+			require.Len(t, moves, 2, "Must not exceed max bytes")
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tFiles already present on receiver aren't moved", testID)
+		{
+			replicated := map[int64][]postgres.ReplicatedFile{}
+			for _, file := range files[1] {
+				file.Holders = []int64{1, 2}
+				replicated[1] = append(replicated[1], file)
+			}
+			require.Empty(t, Plan(nodes, replicated, 0.1, 1000), "Must not plan moves")
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tBalanced nodes aren't touched", testID)
+		{
+			balanced := []postgres.Node{
+				{ID: 1, Name: "first", Capacity: 1000, Used: 450},
+				{ID: 2, Name: "second", Capacity: 1000, Used: 400},
+			}
+			require.Empty(t, Plan(balanced, files, 0.1, 1000), "Must not plan moves")
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package rebalance
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/muskelo/bronze-pheasant/app/server/log"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+	storagepkg "github.com/muskelo/bronze-pheasant/app/server/storage"
+	"github.com/muskelo/bronze-pheasant/app/server/syncm"
+	"github.com/sirupsen/logrus"
+)
+
+// Max number of files of one node considered by one round of planning, the next round
+// considers the next files, so every file is considered after several rounds
+const filesPerNodeLimit = int64(1000)
+
+func New(
+	pg *postgres.Postgres,
+	storage *storagepkg.Storage,
+	sm *syncm.SyncManager,
+	nodeID int64,
+	interval time.Duration,
+	threshold float64,
+	maxBytes int64,
+	dryRun bool,
+) *Rebalancer {
+	return &Rebalancer{
+		pg:        pg,
+		storage:   storage,
+		sm:        sm,
+		nodeID:    nodeID,
+		interval:  interval,
+		threshold: threshold,
+		maxBytes:  maxBytes,
+		dryRun:    dryRun,
+		log:       log.G("rebalancer"),
+		cursors:   map[int64]int64{},
+	}
+}
+
+// Rebalancer reports disk usage of node and pulls copies from the most used nodes,
+// so disk usage evens out. Every node plans moves for the whole cluster but executes only
+// the ones to itself. Plans of nodes can differ, if two nodes pull the same copy the one
+// which finishes second drops its copy.
+type Rebalancer struct {
+	pg        *postgres.Postgres
+	storage   *storagepkg.Storage
+	sm        *syncm.SyncManager
+	nodeID    int64
+	interval  time.Duration
+	threshold float64
+	maxBytes  int64
+	dryRun    bool
+	log       *logrus.Entry
+
+	mutex sync.Mutex
+	// id of the last file considered by planning by node id
+	cursors map[int64]int64
+}
+
+// Plans moves for the whole cluster. Files replicated to every node can't be moved.
+func (r *Rebalancer) Plan(ctx context.Context) ([]Move, error) {
+	moves, _, err := r.plan(ctx)
+	return moves, err
+}
+
+// Plans moves from the current window of files of every node, returns cursors of the next windows
+func (r *Rebalancer) plan(ctx context.Context) (moves []Move, cursors map[int64]int64, err error) {
+	replicationFactor, err := r.pg.GetReplicationFactor(ctx)
+	if err != nil {
+		return
+	}
+	activeNodes, err := r.pg.GetActiveNodes(ctx)
+	if err != nil {
+		return
+	}
+
+	r.mutex.Lock()
+	current := maps.Clone(r.cursors)
+	r.mutex.Unlock()
+
+	cursors = map[int64]int64{}
+	nodes := []postgres.Node{}
+	files := map[int64][]postgres.ReplicatedFile{}
+	for _, node := range activeNodes {
+		if node.Capacity <= 0 {
+			continue
+		}
+		nodeFiles, err := r.pg.GetNodeFiles(ctx, node.ID, current[node.ID], filesPerNodeLimit)
+		if err != nil {
+			return nil, nil, err
+		}
+		// start from the first file after the last window
+		if int64(len(nodeFiles)) == filesPerNodeLimit {
+			cursors[node.ID] = nodeFiles[len(nodeFiles)-1].ID
+		}
+		for _, file := range nodeFiles {
+			if file.ReplicationFactor > 0 || replicationFactor > 0 {
+				files[node.ID] = append(files[node.ID], file)
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return Plan(nodes, files, r.threshold, r.maxBytes), cursors, nil
+}
+
+func (r *Rebalancer) reportUsage(ctx context.Context) error {
+	used, capacity, err := r.storage.Usage()
+	if err != nil {
+		return err
+	}
+	return r.pg.UpdateNodeUsage(ctx, r.nodeID, used, capacity)
+}
+
+func (r *Rebalancer) run(ctx context.Context) error {
+	err := r.reportUsage(ctx)
+	if err != nil {
+		return err
+	}
+
+	moves, cursors, err := r.plan(ctx)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	r.cursors = cursors
+	r.mutex.Unlock()
+
+	for _, move := range moves {
+		if move.ToID != r.nodeID {
+			continue
+		}
+		if r.dryRun {
+			r.log.Infof("Dry run: move %v (%v bytes) from %v", move.FileUUID, move.Size, move.From)
+			continue
+		}
+
+		// copy by workers of sync manager then delete, file which is already being synced
+		// is considered again in the next round
+		queued := r.sm.Enqueue(ctx, move.File, func(ctx context.Context) {
+			r.finishMove(ctx, move)
+		})
+		if !queued {
+			r.log.Infof("Skip move of %v, it's already being synced", move.FileUUID)
+		}
+	}
+	return nil
+}
+
+// Removes source copy of move after file is copied to node, copy of node is removed
+// if another node already moved the source copy
+func (r *Rebalancer) finishMove(ctx context.Context, move Move) {
+	removed, err := r.pg.MoveFileFromNode(ctx, move.FromID, move.File.ID)
+	if err != nil {
+		r.log.Errorf("Failed remove %v from %v: %v", move.FileUUID, move.From, err)
+		return
+	}
+	if removed {
+		r.log.Infof("Moved %v (%v bytes) from %v", move.FileUUID, move.Size, move.From)
+		return
+	}
+
+	replicationFactor, err := r.pg.GetReplicationFactor(ctx)
+	if err != nil {
+		r.log.Errorf("Failed drop extra copy of %v: %v", move.FileUUID, err)
+		return
+	}
+	trimmed, err := r.pg.TrimFileCopy(ctx, r.nodeID, move.File.ID, replicationFactor)
+	if err != nil {
+		r.log.Errorf("Failed drop extra copy of %v: %v", move.FileUUID, err)
+		return
+	}
+	if trimmed {
+		r.log.Infof("Dropped copy of %v, it was moved from %v by another node", move.FileUUID, move.From)
+	}
+}
+
+func (r *Rebalancer) Run(ctx context.Context) error {
+	for {
+		err := r.run(ctx)
+		if err != nil {
+			r.log.Errorf("Rebalance error: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+// Default rebalancer
+
+var (
+	rebalanceInterval  = kingpin.Flag("rebalance.interval", "Interval between rebalancing rounds").Default("10m").Duration()
+	rebalanceThreshold = kingpin.Flag("rebalance.threshold", "Allowed difference of disk usage ratios of nodes").Default("0.1").Float64()
+	rebalanceMaxBytes  = kingpin.Flag("rebalance.max-bytes", "Max bytes moved to node per round, every node plans and pulls its own moves").Default("1GB").Bytes()
+	rebalanceDryRun    = kingpin.Flag("rebalance.dry-run", "Only log planned moves").Bool()
+)
+
+var (
+	Default *Rebalancer
+)
+
+func Init(nodeID int64) {
+	Default = New(
+		postgres.Default,
+		storagepkg.Default,
+		syncm.Default,
+		nodeID,
+		*rebalanceInterval,
+		*rebalanceThreshold,
+		int64(*rebalanceMaxBytes),
+		*rebalanceDryRun,
+	)
+}
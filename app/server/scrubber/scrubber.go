@@ -0,0 +1,203 @@
+package scrubber
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/muskelo/bronze-pheasant/app/server/log"
+	"github.com/muskelo/bronze-pheasant/app/server/pglock"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+	storagepkg "github.com/muskelo/bronze-pheasant/app/server/storage"
+	locklib "github.com/muskelo/bronze-pheasant/lib/lock"
+	"github.com/sirupsen/logrus"
+)
+
+func New(
+	pg *postgres.Postgres,
+	storage *storagepkg.Storage,
+	lock locklib.Lock,
+	nodeID int64,
+	rate int64,
+	interval time.Duration,
+) *Scrubber {
+	return &Scrubber{
+		pg:       pg,
+		storage:  storage,
+		lock:     lock,
+		nodeID:   nodeID,
+		rate:     rate,
+		interval: interval,
+		log:      log.G("scrubber"),
+	}
+}
+
+// Scrubber slowly rereads local files and quarantines the ones with wrong checksum,
+// after that sync manager fetches them again from healthy nodes
+type Scrubber struct {
+	pg       *postgres.Postgres
+	storage  *storagepkg.Storage
+	// scrubbing is paused while lock isn't fresh
+	lock     locklib.Lock
+	nodeID   int64
+	rate     int64
+	interval time.Duration
+	log      *logrus.Entry
+}
+
+func (s *Scrubber) scrubFile(ctx context.Context, uuid string) error {
+	file, err := s.pg.GetFileByUUID(ctx, uuid)
+	if err != nil {
+		return err
+	}
+	// nothing to compare with
+	if !file.IsExist() || file.State != postgres.FileStateReady || file.SHA256 == "" {
+		return nil
+	}
+
+	corrupted, err := s.verifyFile(ctx, uuid, file.SHA256)
+	if err != nil || !corrupted {
+		return err
+	}
+	// copy is unregistered first, file is found again by the next pass if it fails
+	s.log.Warnf("File %v is corrupted, quarantine it", uuid)
+	err = s.pg.RemoveFileFromNode(ctx, s.nodeID, file.ID)
+	if err != nil {
+		return err
+	}
+	return s.storage.QuarantineFile(uuid)
+}
+
+// Rereads local file and reports if its sha256 isn't equal to checksum
+func (s *Scrubber) verifyFile(ctx context.Context, uuid string, checksum string) (corrupted bool, err error) {
+	f, err := s.storage.GetFile(uuid)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, newThrottledReader(ctx, f, s.rate))
+	if err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)) != checksum, nil
+}
+
+// Waits until lock of node is fresh, returns false if ctx is done
+func (s *Scrubber) waitFreshLock(ctx context.Context) bool {
+	if s.lock.IsFresh() {
+		return true
+	}
+	s.log.Warn("Node lock isn't fresh, scrubbing paused")
+	for !s.lock.IsFresh() {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(time.Second):
+		}
+	}
+	s.log.Info("Node lock is fresh, scrubbing resumed")
+	return true
+}
+
+func (s *Scrubber) run(ctx context.Context) error {
+	start := time.Now()
+	scrubbed := 0
+	err := s.storage.WalkFiles(func(uuid string) error {
+		if !s.waitFreshLock(ctx) {
+			return ctx.Err()
+		}
+		err := s.scrubFile(ctx, uuid)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			s.log.Errorf("Failed scrub file %v: %v", uuid, err)
+		}
+		scrubbed++
+		return nil
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.log.Infof("Scrubbed %v files in %v", scrubbed, time.Since(start))
+	return nil
+}
+
+func (s *Scrubber) Run(ctx context.Context) error {
+	for {
+		err := s.run(ctx)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+// Limits read speed to rate bytes per second
+type throttledReader struct {
+	ctx   context.Context
+	r     io.Reader
+	rate  int64
+	start time.Time
+	read  int64
+}
+
+func newThrottledReader(ctx context.Context, r io.Reader, rate int64) *throttledReader {
+	return &throttledReader{ctx: ctx, r: r, rate: rate, start: time.Now()}
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	if err := tr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if tr.rate <= 0 {
+		return tr.r.Read(p)
+	}
+	// don't read more than 1/10 of rate at once to keep speed smooth
+	if max := tr.rate / 10; max > 0 && int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := tr.r.Read(p)
+	tr.read += int64(n)
+
+	expected := time.Duration(float64(tr.read) / float64(tr.rate) * float64(time.Second))
+	if wait := expected - time.Since(tr.start); wait > 0 {
+		select {
+		case <-tr.ctx.Done():
+			return n, tr.ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	return n, err
+}
+
+// Default scrubber
+
+var (
+	scrubberRate     = kingpin.Flag("scrubber.rate", "Max read speed of scrubber, 0 is unlimited").Default("10MB").Bytes()
+	scrubberInterval = kingpin.Flag("scrubber.interval", "Interval between full passes of scrubber").Default("24h").Duration()
+)
+
+var (
+	Default *Scrubber
+)
+
+func Init(nodeID int64) {
+	Default = New(postgres.Default, storagepkg.Default, pglock.Default, nodeID, int64(*scrubberRate), *scrubberInterval)
+}
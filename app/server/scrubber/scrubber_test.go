@@ -0,0 +1,133 @@
+package scrubber
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	uuidp "github.com/google/uuid"
+	storagepkg "github.com/muskelo/bronze-pheasant/app/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThrottledReader(t *testing.T) {
+	testID := 0
+	t.Logf("\tTest %d:\tRead with limited rate", testID)
+	{
+		data := bytes.Repeat([]byte{1}, 1000)
+		start := time.Now()
+
+		n, err := io.Copy(io.Discard, newThrottledReader(context.Background(), bytes.NewReader(data), 4000))
+		require.NoError(t, err, "Must read everything")
+		require.Equal(t, int64(len(data)), n, "Must read every byte")
+
+		elapsed := time.Since(start)
+		require.GreaterOrEqual(t, elapsed, 200*time.Millisecond, "Must not read faster than rate")
+		require.Less(t, elapsed, 2*time.Second, "Must not read much slower than rate")
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tRead with unlimited rate", testID)
+	{
+		data := bytes.Repeat([]byte{1}, 1<<20)
+		start := time.Now()
+
+		n, err := io.Copy(io.Discard, newThrottledReader(context.Background(), bytes.NewReader(data), 0))
+		require.NoError(t, err, "Must read everything")
+		require.Equal(t, int64(len(data)), n, "Must read every byte")
+		require.Less(t, time.Since(start), 200*time.Millisecond, "Must not throttle when rate is 0")
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tStop reading on canceled context", testID)
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := io.Copy(io.Discard, newThrottledReader(ctx, strings.NewReader("hello"), 1))
+		require.ErrorIs(t, err, context.Canceled, "Must return context error")
+	}
+}
+
+func TestVerifyFile(t *testing.T) {
+	workdir := t.TempDir()
+	storage, err := storagepkg.New(workdir)
+	require.NoError(t, err, "Must init new storage")
+	s := New(nil, storage, nil, 1, 0, time.Hour)
+
+	testID := 0
+	t.Logf("\tTest %d:\tKeep file with matching checksum", testID)
+	{
+		uuid := uuidp.NewString()
+		_, checksum, err := storage.WriteFile(uuid, strings.NewReader("hello"))
+		require.NoError(t, err, "Must write file")
+
+		corrupted, err := s.verifyFile(context.Background(), uuid, checksum)
+		require.NoError(t, err, "Must verify file")
+		require.False(t, corrupted, "Must accept healthy file")
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tReport file with checksum mismatch", testID)
+	{
+		uuid := uuidp.NewString()
+		_, checksum, err := storage.WriteFile(uuid, strings.NewReader("hello"))
+		require.NoError(t, err, "Must write file")
+
+		f, err := storage.GetFile(uuid)
+		require.NoError(t, err, "Must open file")
+		path := f.Name()
+		f.Close()
+		require.NoError(t, os.WriteFile(path, []byte("hellO"), 0660), "Must corrupt file")
+
+		corrupted, err := s.verifyFile(context.Background(), uuid, checksum)
+		require.NoError(t, err, "Must verify file")
+		require.True(t, corrupted, "Must report corrupted file")
+		require.True(t, storage.IsFileExist(uuid), "Must not quarantine file before it's unregistered")
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tIgnore not existing file", testID)
+	{
+		corrupted, err := s.verifyFile(context.Background(), uuidp.NewString(), "0000")
+		require.NoError(t, err, "Must ignore not existing file")
+		require.False(t, corrupted, "Must not report not existing file")
+	}
+}
+
+type testLock struct {
+	fresh bool
+}
+
+func (l *testLock) IsFresh() bool {
+	return l.fresh
+}
+
+func (l *testLock) Fence() (int64, int64) {
+	return 1, 1
+}
+
+func TestWaitFreshLock(t *testing.T) {
+	lock := &testLock{fresh: true}
+	s := New(nil, nil, lock, 1, 0, time.Hour)
+
+	testID := 0
+	t.Logf("\tTest %d:\tDon't wait for fresh lock", testID)
+	{
+		require.True(t, s.waitFreshLock(context.Background()), "Must not wait for fresh lock")
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tStop waiting on canceled context", testID)
+	{
+		lock.fresh = false
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		require.False(t, s.waitFreshLock(ctx), "Must not scrub while lock isn't fresh")
+	}
+}
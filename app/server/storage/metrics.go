@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	usedBytesDesc = prometheus.NewDesc(
+		"bronze_pheasant_storage_used_bytes",
+		"Used bytes of filesystem with workdir",
+		nil, nil,
+	)
+	capacityBytesDesc = prometheus.NewDesc(
+		"bronze_pheasant_storage_capacity_bytes",
+		"Total bytes of filesystem with workdir",
+		nil, nil,
+	)
+	filesDesc = prometheus.NewDesc(
+		"bronze_pheasant_storage_files",
+		"Number of files in datadir",
+		nil, nil,
+	)
+)
+
+// Collects usage of storage on scrape, files are counted by storage when they are saved and removed
+type collector struct {
+	storage *Storage
+}
+
+func (c collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- usedBytesDesc
+	ch <- capacityBytesDesc
+	ch <- filesDesc
+}
+
+func (c collector) Collect(ch chan<- prometheus.Metric) {
+	used, capacity, err := c.storage.Usage()
+	if err == nil {
+		ch <- prometheus.MustNewConstMetric(usedBytesDesc, prometheus.GaugeValue, float64(used))
+		ch <- prometheus.MustNewConstMetric(capacityBytesDesc, prometheus.GaugeValue, float64(capacity))
+	}
+	ch <- prometheus.MustNewConstMetric(filesDesc, prometheus.GaugeValue, float64(c.storage.CountFiles()))
+}
@@ -1,15 +1,25 @@
 package storage
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+// Characters used for names of subdirectories of datadir
+const storageChars = "abcdefghijklmnopqrstuvwxyz0123456789"
 
 func New(workdir string) (*Storage, error) {
 	err := os.Mkdir(workdir, 0770)
@@ -20,37 +30,83 @@ func New(workdir string) (*Storage, error) {
 	if err != nil && !os.IsExist(err) {
 		return nil, err
 	}
+	err = os.Mkdir(filepath.Join(workdir, "quarantinedfiles"), 0770)
+	if err != nil && !os.IsExist(err) {
+		return nil, err
+	}
 	err = os.Mkdir(filepath.Join(workdir, "tmpfiles"), 0770)
 	if err != nil && !os.IsExist(err) {
 		return nil, err
 	}
+	err = os.Mkdir(filepath.Join(workdir, "uploadfiles"), 0770)
+	if err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	err = moveLegacyUploadfiles(workdir)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Mkdir(filepath.Join(workdir, "partfiles"), 0770)
+	if err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	err = moveLegacyPartfiles(workdir)
+	if err != nil {
+		return nil, err
+	}
 	err = os.Mkdir(filepath.Join(workdir, "files"), 0770)
 	if err != nil && !os.IsExist(err) {
 		return nil, err
 	}
-	for _, c := range "abcdefghijklmnopqrstuvwxyz0123456789" {
+	for _, c := range storageChars {
 		err = os.Mkdir(filepath.Join(workdir, "files", string(c)), 0770)
 		if err != nil && !os.IsExist(err) {
 			return nil, err
 		}
-		for _, cc := range "abcdefghijklmnopqrstuvwxyz0123456789" {
+		for _, cc := range storageChars {
 			err = os.Mkdir(filepath.Join(workdir, "files", string(c), string(cc)), 0770)
 			if err != nil && !os.IsExist(err) {
 				return nil, err
 			}
 		}
 	}
-	return &Storage{
+	s := &Storage{
 		workdir: workdir,
-	}, nil
+	}
+	// files are counted once, after that counter is updated when files enter or leave datadir
+	files := int64(0)
+	err = s.WalkFiles(func(uuid string) error {
+		files++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.files.Store(files)
+	return s, nil
 }
 
 type Storage struct {
 	workdir string
 	mutex   sync.Mutex
+	// number of files in datadir
+	files atomic.Int64
+}
+
+var ErrChecksumMismatch = errors.New("Checksum mismatch")
+
+// Writes file and returns its size and hex encoded sha256
+func (s *Storage) WriteFile(uuid string, src io.Reader) (written int64, checksum string, err error) {
+	return s.writeFile(uuid, src, "")
 }
 
-func (s *Storage) WriteFile(uuid string, src io.Reader) (written int64, err error) {
+// Writes file only if its sha256 equals to checksum, otherwise returns ErrChecksumMismatch
+func (s *Storage) WriteFileWithChecksum(uuid string, src io.Reader, checksum string) (written int64, err error) {
+	written, _, err = s.writeFile(uuid, src, checksum)
+	return
+}
+
+func (s *Storage) writeFile(uuid string, src io.Reader, expectedChecksum string) (written int64, checksum string, err error) {
 	// prepare
 	filePath := s.filePath(uuid)
 	tmpfilePath := s.tmpfilePath(uuid)
@@ -67,26 +123,193 @@ func (s *Storage) WriteFile(uuid string, src io.Reader) (written int64, err erro
 		return
 	}
 	defer tmpfile.Close()
-	written, err = io.Copy(tmpfile, src)
+	hash := sha256.New()
+	written, err = io.Copy(io.MultiWriter(tmpfile, hash), src)
 	if err != nil {
-		err = fmt.Errorf("Failed write to tmp file: %v\n", err)
+		os.Remove(tmpfilePath)
+		err = fmt.Errorf("Failed write to tmp file: %w", err)
 		return
 	}
 	tmpfile.Close()
+	checksum = hex.EncodeToString(hash.Sum(nil))
+	if expectedChecksum != "" && checksum != expectedChecksum {
+		os.Remove(tmpfilePath)
+		err = ErrChecksumMismatch
+		return
+	}
 
+	err = s.promote(tmpfilePath, filePath)
+	return
+}
+
+// Moves tmp file to datadir, tmp file is removed if file already exists
+func (s *Storage) promote(tmpfilePath string, filePath string) error {
 	// mv from tmpdir to datadir
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	// prevent overwrite file if datadir
-	_, err = os.Stat(filePath)
+	_, err := os.Stat(filePath)
 	if err == nil {
-		err = os.ErrExist
-		return
+		os.Remove(tmpfilePath)
+		return os.ErrExist
 	}
 	err = os.Rename(tmpfilePath, filePath)
+	if err != nil {
+		return err
+	}
+	s.files.Add(1)
+	return nil
+}
+
+// Resumable uploads used to be stored in tmpfiles, where they were removed by gc
+func moveLegacyUploadfiles(workdir string) error {
+	paths, err := filepath.Glob(filepath.Join(workdir, "tmpfiles", "*.upload"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		uuid := strings.TrimSuffix(filepath.Base(path), ".upload")
+		err = os.Rename(path, filepath.Join(workdir, "uploadfiles", uuid))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parts of multipart uploads used to be stored in tmpfiles, where they were removed by gc
+func moveLegacyPartfiles(workdir string) error {
+	paths, err := filepath.Glob(filepath.Join(workdir, "tmpfiles", "*.part"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		err = os.Rename(path, filepath.Join(workdir, "partfiles", filepath.Base(path)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var ErrOffsetMismatch = errors.New("Offset mismatch")
+
+// Creates empty file for resumable upload. Upload files are kept in own directory which isn't
+// cleaned by gc, they are removed when upload finishes, is terminated or expires.
+func (s *Storage) CreateUploadfile(uuid string) error {
+	f, err := os.OpenFile(s.uploadfilePath(uuid), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0660)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Appends data to file of resumable upload at offset, returns ErrOffsetMismatch if file is
+// shorter than offset. Bytes after offset which weren't confirmed before crash are dropped.
+// Written bytes are kept on error.
+func (s *Storage) AppendUploadfile(uuid string, offset int64, src io.Reader) (written int64, err error) {
+	f, err := os.OpenFile(s.uploadfilePath(uuid), os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return
+	}
+	if info.Size() < offset {
+		err = ErrOffsetMismatch
+		return
+	}
+	if info.Size() > offset {
+		err = f.Truncate(offset)
+		if err != nil {
+			return
+		}
+	}
+	written, err = io.Copy(f, src)
+	return
+}
+
+// Moves complete file of resumable upload to datadir and returns its size and sha256
+func (s *Storage) PromoteUploadfile(uuid string) (size int64, checksum string, err error) {
+	uploadfilePath := s.uploadfilePath(uuid)
+	f, err := os.Open(uploadfilePath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err = io.Copy(hash, f)
+	if err != nil {
+		return
+	}
+	f.Close()
+	checksum = hex.EncodeToString(hash.Sum(nil))
+	err = s.promote(uploadfilePath, s.filePath(uuid))
+	return
+}
+
+// Moves promoted file of resumable upload back from datadir, so upload which failed to finish
+// can be finished again or expired. Returns os.ErrNotExist if file isn't promoted.
+func (s *Storage) DemoteUploadfile(uuid string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := os.Rename(s.filePath(uuid), s.uploadfilePath(uuid))
+	if err != nil {
+		return err
+	}
+	s.files.Add(-1)
+	return nil
+}
+
+func (s *Storage) RemoveUploadfile(uuid string) error {
+	return os.Remove(s.uploadfilePath(uuid))
+}
+
+// Writes part of multipart upload, part with the same number is replaced. Parts are kept in
+// own directory which isn't cleaned by gc, they are removed when upload completes, is aborted
+// or expires. Returns size and hex encoded sha256 of part.
+func (s *Storage) WritePartfile(uuid string, number int64, src io.Reader) (written int64, checksum string, err error) {
+	tmpfile, err := os.CreateTemp(filepath.Join(s.workdir, "tmpfiles"), uuid+".*")
+	if err != nil {
+		err = fmt.Errorf("Failed create tmp file: %v\n", err)
+		return
+	}
+	defer tmpfile.Close()
+	hash := sha256.New()
+	written, err = io.Copy(io.MultiWriter(tmpfile, hash), src)
+	if err != nil {
+		os.Remove(tmpfile.Name())
+		err = fmt.Errorf("Failed write to tmp file: %w", err)
+		return
+	}
+	tmpfile.Close()
+	checksum = hex.EncodeToString(hash.Sum(nil))
+	err = os.Rename(tmpfile.Name(), s.partfilePath(uuid, number))
 	return
 }
 
+func (s *Storage) GetPartfile(uuid string, number int64) (*os.File, error) {
+	return os.Open(s.partfilePath(uuid, number))
+}
+
+// Removes all parts of multipart upload
+func (s *Storage) RemovePartfiles(uuid string) error {
+	paths, err := filepath.Glob(filepath.Join(s.workdir, "partfiles", uuid+".*.part"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		err = os.Remove(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *Storage) ReadFile(uuid string, dst io.Writer) error {
 	f, err := os.OpenFile(s.filePath(uuid), os.O_RDONLY, 0660)
 	if err != nil {
@@ -110,15 +333,23 @@ func (s *Storage) GetFileSize(uuid string) int64 {
 	}
 }
 
+// Moves file to trash, the same uuid can be removed several times within retention
 func (s *Storage) RemoveFile(uuid string) error {
-	filePath := s.filePath(uuid)
+	return s.moveFile(s.filePath(uuid), s.removedfilePath(uuid, time.Now()))
+}
+
+// Moves probably corrupted file out of datadir but keeps it for investigation
+func (s *Storage) QuarantineFile(uuid string) error {
+	return s.moveFile(s.filePath(uuid), s.quarantinedfilePath(uuid, time.Now()))
+}
+
+func (s *Storage) moveFile(filePath string, dstPath string) error {
 	_, err := os.Stat(filePath)
 	if err != nil {
 		return err
 	}
 
-	removedfilePath := s.removedfilePath(uuid)
-	_, err = os.Stat(removedfilePath)
+	_, err = os.Stat(dstPath)
 	if err == nil {
 		return os.ErrExist
 	}
@@ -127,11 +358,124 @@ func (s *Storage) RemoveFile(uuid string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	// prevent overwrite file
-	_, err = os.Stat(removedfilePath)
+	_, err = os.Stat(dstPath)
 	if err == nil {
 		return os.ErrExist
 	}
-	return os.Rename(filePath, removedfilePath)
+	err = os.Rename(filePath, dstPath)
+	if err != nil {
+		return err
+	}
+	s.files.Add(-1)
+	// retention of moved files is counted from the moment of moving
+	now := time.Now()
+	return os.Chtimes(dstPath, now, now)
+}
+
+// Returns number of files in datadir
+func (s *Storage) CountFiles() int64 {
+	return s.files.Load()
+}
+
+// Calls fn for uuid of every file in datadir
+func (s *Storage) WalkFiles(fn func(uuid string) error) error {
+	for _, c := range storageChars {
+		for _, cc := range storageChars {
+			entries, err := os.ReadDir(filepath.Join(s.workdir, "files", string(c), string(cc)))
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				if entry.IsDir() {
+					continue
+				}
+				err = fn(entry.Name())
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Removes temporary files not modified for maxAge, returns number of reclaimed bytes
+func (s *Storage) CleanTmpfiles(maxAge time.Duration) (int64, error) {
+	return purgeDir(filepath.Join(s.workdir, "tmpfiles"), time.Now().Add(-maxAge))
+}
+
+// Removes every temporary file, must be called only when no writes are in progress, e.g. on start.
+// Parts of multipart uploads and resumable uploads aren't temporary files. Returns number of
+// reclaimed bytes.
+func (s *Storage) ClearTmpfiles() (int64, error) {
+	return purgeDir(filepath.Join(s.workdir, "tmpfiles"), time.Now().Add(time.Hour))
+}
+
+// Removes files moved to trash more than retention ago, returns number of reclaimed bytes
+func (s *Storage) PurgeRemovedfiles(retention time.Duration) (int64, error) {
+	return purgeDir(filepath.Join(s.workdir, "removedfiles"), time.Now().Add(-retention))
+}
+
+// Removes files quarantined more than retention ago, returns number of reclaimed bytes
+func (s *Storage) PurgeQuarantinedfiles(retention time.Duration) (int64, error) {
+	return purgeDir(filepath.Join(s.workdir, "quarantinedfiles"), time.Now().Add(-retention))
+}
+
+func purgeDir(dir string, olderThan time.Time) (reclaimed int64, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, infoErr := entry.Info()
+		if errors.Is(infoErr, os.ErrNotExist) {
+			continue
+		}
+		if infoErr != nil {
+			err = infoErr
+			return
+		}
+		if info.IsDir() || !info.ModTime().Before(olderThan) {
+			continue
+		}
+		rmErr := os.Remove(filepath.Join(dir, entry.Name()))
+		if errors.Is(rmErr, os.ErrNotExist) {
+			continue
+		}
+		if rmErr != nil {
+			err = rmErr
+			return
+		}
+		reclaimed += info.Size()
+	}
+	return
+}
+
+// Checks that files can be written to workdir by creating and removing tmp file
+func (s *Storage) CheckWritable() error {
+	f, err := os.CreateTemp(filepath.Join(s.workdir, "tmpfiles"), "check-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write([]byte{0})
+	closeErr := f.Close()
+	os.Remove(f.Name())
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// Returns used and total bytes of filesystem with workdir
+func (s *Storage) Usage() (used int64, capacity int64, err error) {
+	stat := syscall.Statfs_t{}
+	err = syscall.Statfs(s.workdir, &stat)
+	if err != nil {
+		return
+	}
+	capacity = int64(stat.Blocks) * int64(stat.Bsize)
+	used = capacity - int64(stat.Bavail)*int64(stat.Bsize)
+	return
 }
 
 func (s *Storage) IsFileExist(uuid string) bool {
@@ -147,8 +491,20 @@ func (s *Storage) tmpfilePath(uuid string) string {
 	return filepath.Join(s.workdir, "tmpfiles", uuid)
 }
 
-func (s *Storage) removedfilePath(uuid string) string {
-	return filepath.Join(s.workdir, "removedfiles", uuid)
+func (s *Storage) uploadfilePath(uuid string) string {
+	return filepath.Join(s.workdir, "uploadfiles", uuid)
+}
+
+func (s *Storage) partfilePath(uuid string, number int64) string {
+	return filepath.Join(s.workdir, "partfiles", fmt.Sprintf("%s.%d.part", uuid, number))
+}
+
+func (s *Storage) removedfilePath(uuid string, at time.Time) string {
+	return filepath.Join(s.workdir, "removedfiles", fmt.Sprintf("%s.%d", uuid, at.UnixNano()))
+}
+
+func (s *Storage) quarantinedfilePath(uuid string, at time.Time) string {
+	return filepath.Join(s.workdir, "quarantinedfiles", fmt.Sprintf("%s.%d", uuid, at.Unix()))
 }
 
 // Default storage
@@ -162,5 +518,8 @@ var Default *Storage
 func Init() error {
 	var err error
 	Default, err = New(*storageWorkdir)
-	return err
+	if err != nil {
+		return err
+	}
+	return prometheus.Register(collector{storage: Default})
 }
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
     uuidp "github.com/google/uuid"
 	"github.com/stretchr/testify/require"
@@ -20,10 +21,10 @@ func TestStorage(t *testing.T) {
 		var err error
 		workdir := t.TempDir()
 
-		s, err = NewStorage(workdir)
+		s, err = New(workdir)
 		require.NoError(t, err, "Must init new storage")
 
-		s, err = NewStorage(workdir)
+		s, err = New(workdir)
 		require.NoError(t, err, "Must reinit new storage")
 	}
 
@@ -33,11 +34,11 @@ func TestStorage(t *testing.T) {
 
 		t.Logf("\tTest %d:\tTest IsExist method", testID)
 		{
-			uuid := uuidp.Generate().String()
+			uuid := uuidp.NewString()
 
 			require.False(t, s.IsFileExist(uuid), "Must return false for not existing file")
 
-			err := s.WriteFile(uuid, strings.NewReader("hello"))
+			_, _, err := s.WriteFile(uuid, strings.NewReader("hello"))
 			require.NoError(t, err, "Must write file")
 
 			require.True(t, s.IsFileExist(uuid), "Must return true for existing file")
@@ -47,10 +48,21 @@ func TestStorage(t *testing.T) {
 
 		t.Logf("\tTest %d:\tTest path method", testID)
 		{
-			expected_path := filepath.Join(s.Workdir, "files", "3/a/3adc6469-2691-4ba4-8245-94b0c30b15ef")
+			expected_path := filepath.Join(s.workdir, "files", "3/a/3adc6469-2691-4ba4-8245-94b0c30b15ef")
 			path := s.filePath("3adc6469-2691-4ba4-8245-94b0c30b15ef")
 			require.Equal(t, expected_path, path, "Return not exppected path")
 		}
+
+		testID++
+
+		t.Logf("\tTest %d:\tTest CheckWritable method", testID)
+		{
+			require.NoError(t, s.CheckWritable(), "Must write to workdir")
+
+			entries, err := os.ReadDir(filepath.Join(s.workdir, "tmpfiles"))
+			require.NoError(t, err, "Must read tmp dir")
+			require.Empty(t, entries, "Must remove check file")
+		}
 	}
 
 	t.Log("Test WriteFile method")
@@ -59,10 +71,10 @@ func TestStorage(t *testing.T) {
 		t.Logf("\tTest %d:\tWrite file", testID)
 		{
 			text := "Test text"
-			uuid := uuidp.Generate().String()
+			uuid := uuidp.NewString()
 			src := strings.NewReader(text)
 
-			err := s.WriteFile(uuid, src)
+			_, _, err := s.WriteFile(uuid, src)
 			require.NoError(t, err, "Must write file")
 
 			f, err := os.Open(s.filePath(uuid))
@@ -76,15 +88,39 @@ func TestStorage(t *testing.T) {
 		testID++
 		t.Logf("\tTest %d:\tTry overwrite existing file", testID)
 		{
-			uuid := uuidp.Generate().String()
+			uuid := uuidp.NewString()
 			src := strings.NewReader("Test")
 
-			err := s.WriteFile(uuid, src)
+			_, _, err := s.WriteFile(uuid, src)
 			require.NoError(t, err, "Must write file")
 
-			err = s.WriteFile(uuid, src)
+			_, _, err = s.WriteFile(uuid, src)
 			require.ErrorIs(t, err, os.ErrExist, "Must return error when try overwrite file")
 		}
+
+		testID++
+		t.Logf("\tTest %d:\tWrite file returns checksum", testID)
+		{
+			uuid := uuidp.NewString()
+
+			written, checksum, err := s.WriteFile(uuid, strings.NewReader("hello"))
+			require.NoError(t, err, "Must write file")
+			require.Equal(t, int64(5), written, "Must return number of written bytes")
+			require.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", checksum, "Must return sha256 of file")
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWrite file with wrong checksum", testID)
+		{
+			uuid := uuidp.NewString()
+
+			_, err := s.WriteFileWithChecksum(uuid, strings.NewReader("hello"), "0000")
+			require.ErrorIs(t, err, ErrChecksumMismatch, "Must return error when checksum mismatch")
+			require.False(t, s.IsFileExist(uuid), "Must not keep corrupted file")
+
+			_, err = os.Stat(s.tmpfilePath(uuid))
+			require.ErrorIs(t, err, os.ErrNotExist, "Must remove tmp file")
+		}
 	}
 
 	t.Log("Test ReadFile method")
@@ -93,9 +129,9 @@ func TestStorage(t *testing.T) {
 		t.Logf("\tTest %d:\tRead file", testID)
 		{
 			text := "My text"
-			uuid := uuidp.Generate().String()
+			uuid := uuidp.NewString()
 
-			err := s.WriteFile(uuid, strings.NewReader(text))
+			_, _, err := s.WriteFile(uuid, strings.NewReader(text))
 			require.NoError(t, err, "Must write file")
 
 			buf := new(bytes.Buffer)
@@ -107,7 +143,7 @@ func TestStorage(t *testing.T) {
 		testID++
 		t.Logf("\tTest %d:\tRead not existing file", testID)
 		{
-			err := s.ReadFile(uuidp.Generate().String(), nil)
+			err := s.ReadFile(uuidp.NewString(), nil)
 			require.ErrorIs(t, err, os.ErrNotExist, "Must return NotExist error")
 		}
 	}
@@ -118,16 +154,20 @@ func TestStorage(t *testing.T) {
 		t.Logf("\tTest %d:\tRemove file", testID)
 		{
 			text := "Test text"
-			uuid := uuidp.Generate().String()
+			uuid := uuidp.NewString()
 			src := strings.NewReader(text)
 
-			err := s.WriteFile(uuid, src)
+			_, _, err := s.WriteFile(uuid, src)
 			require.NoError(t, err, "Must write file")
 
 			err = s.RemoveFile(uuid)
 			require.NoError(t, err, "Must remove file")
 
-			f, err := os.Open(s.removedfilePath(uuid))
+			paths, err := filepath.Glob(filepath.Join(s.workdir, "removedfiles", uuid+".*"))
+			require.NoError(t, err, "Must list removed files")
+			require.Len(t, paths, 1, "Must move file to trash")
+
+			f, err := os.Open(paths[0])
 			require.NoError(t, err, "Must open removed file")
 
 			b, err := io.ReadAll(f)
@@ -135,11 +175,188 @@ func TestStorage(t *testing.T) {
 			require.Equal(t, string(b), text, "The read string must be equal to the original string")
 		}
 
+		testID++
+		t.Logf("\tTest %d:\tRemove the same file twice", testID)
+		{
+			uuid := uuidp.NewString()
+
+			_, _, err := s.WriteFile(uuid, strings.NewReader("first"))
+			require.NoError(t, err, "Must write file")
+			require.NoError(t, s.RemoveFile(uuid), "Must remove file")
+
+			_, _, err = s.WriteFile(uuid, strings.NewReader("second"))
+			require.NoError(t, err, "Must write file again")
+			require.NoError(t, s.RemoveFile(uuid), "Must remove file while previous copy is in trash")
+
+			paths, err := filepath.Glob(filepath.Join(s.workdir, "removedfiles", uuid+".*"))
+			require.NoError(t, err, "Must list removed files")
+			require.Len(t, paths, 2, "Must keep both removed copies")
+		}
+
 		testID++
 		t.Logf("\tTest %d:\tTry remove not existing file", testID)
 		{
-			err := s.RemoveFile(uuidp.Generate().String())
+			err := s.RemoveFile(uuidp.NewString())
 			require.ErrorIs(t, err, os.ErrNotExist, "Must return NotExist error")
 		}
 	}
+
+	t.Log("Test CountFiles method")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tCounter follows saved and removed files", testID)
+		{
+			before := s.CountFiles()
+			uuid := uuidp.NewString()
+
+			_, _, err := s.WriteFile(uuid, strings.NewReader("hello"))
+			require.NoError(t, err, "Must write file")
+			require.Equal(t, before+1, s.CountFiles(), "Saved file must be counted")
+
+			_, _, err = s.WriteFile(uuid, strings.NewReader("hello"))
+			require.ErrorIs(t, err, os.ErrExist)
+			require.Equal(t, before+1, s.CountFiles(), "Existing file must not be counted twice")
+
+			require.NoError(t, s.RemoveFile(uuid), "Must remove file")
+			require.Equal(t, before, s.CountFiles(), "Removed file must not be counted")
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tFiles are counted on start", testID)
+		{
+			reopened, err := New(s.workdir)
+			require.NoError(t, err, "Must reinit storage")
+			require.Equal(t, s.CountFiles(), reopened.CountFiles(), "Must count existing files")
+		}
+	}
+
+	t.Log("Test ClearTmpfiles method")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tFresh tmp file left by crash is removed", testID)
+		{
+			uuid := uuidp.NewString()
+
+			require.NoError(t, os.WriteFile(s.tmpfilePath(uuid), []byte("hello"), 0660), "Must create tmp file")
+			_, _, err := s.WritePartfile(uuid, 1, strings.NewReader("hello"))
+			require.NoError(t, err, "Must write part file")
+
+			reclaimed, err := s.ClearTmpfiles()
+			require.NoError(t, err, "Must clear tmp files")
+			require.Equal(t, int64(5), reclaimed, "Must remove fresh tmp file")
+
+			_, _, err = s.WriteFile(uuid, strings.NewReader("hello"))
+			require.NoError(t, err, "Must write file after tmp file is cleared")
+			f, err := s.GetPartfile(uuid, 1)
+			require.NoError(t, err, "Must keep part file")
+			f.Close()
+		}
+	}
+
+	t.Log("Test resumable upload methods")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tUpload file isn't removed by tmp files cleaning", testID)
+		{
+			uuid := uuidp.NewString()
+
+			require.NoError(t, s.CreateUploadfile(uuid), "Must create upload file")
+			_, err := s.AppendUploadfile(uuid, 0, strings.NewReader("hello"))
+			require.NoError(t, err, "Must append to upload file")
+
+			_, err = s.CleanTmpfiles(-time.Hour)
+			require.NoError(t, err, "Must clean tmp files")
+
+			_, err = s.AppendUploadfile(uuid, 5, strings.NewReader(" world"))
+			require.NoError(t, err, "Must keep upload file")
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tUnconfirmed bytes are dropped on resume", testID)
+		{
+			uuid := uuidp.NewString()
+
+			require.NoError(t, s.CreateUploadfile(uuid), "Must create upload file")
+			_, err := s.AppendUploadfile(uuid, 0, strings.NewReader("hello"))
+			require.NoError(t, err, "Must append to upload file")
+
+			_, err = s.AppendUploadfile(uuid, 3, strings.NewReader("p!"))
+			require.NoError(t, err, "Must resume from offset")
+
+			size, checksum, err := s.PromoteUploadfile(uuid)
+			require.NoError(t, err, "Must promote upload file")
+			require.Equal(t, int64(5), size, "Must drop bytes after offset")
+
+			_, expected, err := s.WriteFile(uuidp.NewString(), strings.NewReader("help!"))
+			require.NoError(t, err, "Must write file")
+			require.Equal(t, expected, checksum, "Must contain bytes before offset and appended ones")
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tResume after lost bytes", testID)
+		{
+			uuid := uuidp.NewString()
+
+			require.NoError(t, s.CreateUploadfile(uuid), "Must create upload file")
+			_, err := s.AppendUploadfile(uuid, 5, strings.NewReader("hello"))
+			require.ErrorIs(t, err, ErrOffsetMismatch, "Must return error when file is shorter than offset")
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tDemote promoted upload", testID)
+		{
+			uuid := uuidp.NewString()
+
+			require.NoError(t, s.CreateUploadfile(uuid), "Must create upload file")
+			_, err := s.AppendUploadfile(uuid, 0, strings.NewReader("hello"))
+			require.NoError(t, err, "Must append to upload file")
+			_, _, err = s.PromoteUploadfile(uuid)
+			require.NoError(t, err, "Must promote upload file")
+
+			require.NoError(t, s.DemoteUploadfile(uuid), "Must demote promoted file")
+			require.False(t, s.IsFileExist(uuid), "Demoted file must leave datadir")
+			written, err := s.AppendUploadfile(uuid, 5, strings.NewReader(""))
+			require.NoError(t, err, "Upload must be resumable after demote")
+			require.Equal(t, int64(0), written)
+			_, _, err = s.PromoteUploadfile(uuid)
+			require.NoError(t, err, "Must promote upload file again")
+
+			require.NoError(t, s.RemoveFile(uuid))
+			require.ErrorIs(t, s.DemoteUploadfile(uuid), os.ErrNotExist, "Must return error when file isn't promoted")
+		}
+	}
+
+	t.Log("Test multipart upload methods")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tPart file isn't removed by tmp files cleaning", testID)
+		{
+			uuid := uuidp.NewString()
+
+			_, _, err := s.WritePartfile(uuid, 1, strings.NewReader("hello"))
+			require.NoError(t, err, "Must write part file")
+
+			_, err = s.CleanTmpfiles(-time.Hour)
+			require.NoError(t, err, "Must clean tmp files")
+
+			f, err := s.GetPartfile(uuid, 1)
+			require.NoError(t, err, "Must keep part file")
+			f.Close()
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tRemove part files", testID)
+		{
+			uuid := uuidp.NewString()
+
+			_, _, err := s.WritePartfile(uuid, 1, strings.NewReader("hello"))
+			require.NoError(t, err, "Must write part file")
+			_, _, err = s.WritePartfile(uuid, 2, strings.NewReader("world"))
+			require.NoError(t, err, "Must write part file")
+
+			require.NoError(t, s.RemovePartfiles(uuid), "Must remove part files")
+			_, err = s.GetPartfile(uuid, 1)
+			require.ErrorIs(t, err, os.ErrNotExist, "Part file must be removed")
+		}
+	}
 }
@@ -0,0 +1,159 @@
+package storagegc
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/muskelo/bronze-pheasant/app/server/log"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+	storagepkg "github.com/muskelo/bronze-pheasant/app/server/storage"
+	"github.com/sirupsen/logrus"
+)
+
+func New(
+	pg *postgres.Postgres,
+	storage *storagepkg.Storage,
+	nodeID int64,
+	retention time.Duration,
+	tmpMaxAge time.Duration,
+	interval time.Duration,
+) *GC {
+	return &GC{
+		pg:        pg,
+		storage:   storage,
+		nodeID:    nodeID,
+		retention: retention,
+		tmpMaxAge: tmpMaxAge,
+		interval:  interval,
+		log:       log.G("storagegc"),
+	}
+}
+
+// Garbage collector for removed and temporary files of storage and expired resumable uploads
+type GC struct {
+	pg        *postgres.Postgres
+	storage   *storagepkg.Storage
+	nodeID    int64
+	retention time.Duration
+	tmpMaxAge time.Duration
+	interval  time.Duration
+	log       *logrus.Entry
+}
+
+// Removes stale temporary files
+func (gc *GC) CleanTmpfiles() {
+	reclaimed, err := gc.storage.CleanTmpfiles(gc.tmpMaxAge)
+	if err != nil {
+		gc.log.Errorf("Failed clean tmp files: %v", err)
+	}
+	gc.log.Infof("Cleaned tmp files, reclaimed %v bytes", reclaimed)
+}
+
+// Removes all temporary files left by crash, tmp file of uuid blocks its sync and upload.
+// Must be called before any writes start.
+func (gc *GC) ClearTmpfiles() {
+	reclaimed, err := gc.storage.ClearTmpfiles()
+	if err != nil {
+		gc.log.Errorf("Failed clear tmp files: %v", err)
+	}
+	gc.log.Infof("Cleared tmp files, reclaimed %v bytes", reclaimed)
+}
+
+// Removes files which are in trash or quarantine longer than retention
+func (gc *GC) PurgeRemovedfiles() {
+	reclaimed, err := gc.storage.PurgeRemovedfiles(gc.retention)
+	if err != nil {
+		gc.log.Errorf("Failed purge removed files: %v", err)
+	}
+	gc.log.Infof("Purged removed files, reclaimed %v bytes", reclaimed)
+
+	reclaimed, err = gc.storage.PurgeQuarantinedfiles(gc.retention)
+	if err != nil {
+		gc.log.Errorf("Failed purge quarantined files: %v", err)
+	}
+	gc.log.Infof("Purged quarantined files, reclaimed %v bytes", reclaimed)
+}
+
+// Removes expired resumable uploads of node together with their files and data
+func (gc *GC) ExpireUploads(ctx context.Context) {
+	uploads, err := gc.pg.GetExpiredUploads(ctx, gc.nodeID)
+	if err != nil {
+		gc.log.Errorf("Failed get expired uploads: %v", err)
+		return
+	}
+	for _, upload := range uploads {
+		// node crashed while upload was finished, its data is in datadir but isn't registered
+		err = gc.storage.DemoteUploadfile(upload.FileUUID)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			gc.log.Errorf("Failed expire upload %v: %v", upload.FileUUID, err)
+			continue
+		}
+		// upload row is removed together with file
+		err = gc.pg.AbortFile(ctx, upload.FileID)
+		if err != nil {
+			gc.log.Errorf("Failed expire upload %v: %v", upload.FileUUID, err)
+			continue
+		}
+		err = gc.storage.RemoveUploadfile(upload.FileUUID)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			gc.log.Errorf("Failed remove data of expired upload %v: %v", upload.FileUUID, err)
+			continue
+		}
+		gc.log.Infof("Expired upload %v", upload.FileUUID)
+	}
+}
+
+// Removes expired multipart uploads of node together with their parts
+func (gc *GC) ExpireMultipartUploads(ctx context.Context) {
+	uploads, err := gc.pg.GetExpiredMultipartUploads(ctx, gc.nodeID)
+	if err != nil {
+		gc.log.Errorf("Failed get expired multipart uploads: %v", err)
+		return
+	}
+	for _, upload := range uploads {
+		err = gc.pg.DeleteMultipartUpload(ctx, upload.ID)
+		if err != nil {
+			gc.log.Errorf("Failed expire multipart upload %v: %v", upload.UUID, err)
+			continue
+		}
+		err = gc.storage.RemovePartfiles(upload.UUID)
+		if err != nil {
+			gc.log.Errorf("Failed remove parts of expired multipart upload %v: %v", upload.UUID, err)
+			continue
+		}
+		gc.log.Infof("Expired multipart upload %v", upload.UUID)
+	}
+}
+
+func (gc *GC) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(gc.interval):
+		}
+		gc.CleanTmpfiles()
+		gc.PurgeRemovedfiles()
+		gc.ExpireUploads(ctx)
+		gc.ExpireMultipartUploads(ctx)
+	}
+}
+
+// Default gc
+
+var (
+	storagegcRetention = kingpin.Flag("storagegc.retention", "How long removed and quarantined files are kept").Default("168h").Duration()
+	storagegcTmpMaxAge = kingpin.Flag("storagegc.tmp-max-age", "Temporary files not modified for this time are removed").Default("24h").Duration()
+	storagegcInterval  = kingpin.Flag("storagegc.interval", "Interval between garbage collections").Default("1h").Duration()
+)
+
+var (
+	Default *GC
+)
+
+func Init(nodeID int64) {
+	Default = New(postgres.Default, storagepkg.Default, nodeID, *storagegcRetention, *storagegcTmpMaxAge, *storagegcInterval)
+}
@@ -0,0 +1,22 @@
+package syncm
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	syncBacklog = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "bronze_pheasant_sync_backlog_files",
+		Help: "Number of files which node must fetch, found by last full scan",
+	})
+	syncDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "bronze_pheasant_sync_file_duration_seconds",
+		Help:    "Time to fetch file from another node",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+	})
+	syncFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "bronze_pheasant_sync_failures_total",
+		Help: "Number of files which weren't fetched from any node",
+	})
+)
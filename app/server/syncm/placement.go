@@ -0,0 +1,64 @@
+package syncm
+
+import (
+	"hash/fnv"
+	"slices"
+
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+)
+
+// Rendezvous hashing weight of node for file
+func placementScore(fileUUID string, nodeName string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(fileUUID))
+	h.Write([]byte{'/'})
+	h.Write([]byte(nodeName))
+	return h.Sum64()
+}
+
+// Orders nodes by preference to hold file, the first is the most preferred
+func PlacementOrder(fileUUID string, nodes []postgres.Node) []postgres.Node {
+	ordered := slices.Clone(nodes)
+	slices.SortFunc(ordered, func(a, b postgres.Node) int {
+		sa, sb := placementScore(fileUUID, a.Name), placementScore(fileUUID, b.Name)
+		if sa > sb {
+			return -1
+		}
+		if sa < sb {
+			return 1
+		}
+		return 0
+	})
+	return ordered
+}
+
+// Reports whether node must fetch file. Only so many of active nodes without file as
+// missing copies fetch it, chosen by rendezvous hashing, 0 replicationFactor means every node.
+func ShouldHold(fileUUID string, replicationFactor int64, holders []int64, activeNodes []postgres.Node, nodeID int64) bool {
+	if slices.Contains(holders, nodeID) {
+		return false
+	}
+	if replicationFactor == 0 {
+		return true
+	}
+	missing := replicationFactor - int64(len(holders))
+	if missing <= 0 {
+		return false
+	}
+
+	candidates := []postgres.Node{}
+	for _, node := range activeNodes {
+		if !slices.Contains(holders, node.ID) {
+			candidates = append(candidates, node)
+		}
+	}
+	for i, node := range PlacementOrder(fileUUID, candidates) {
+		if int64(i) >= missing {
+			break
+		}
+		if node.ID == nodeID {
+			return true
+		}
+	}
+	return false
+}
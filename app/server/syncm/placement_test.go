@@ -0,0 +1,56 @@
+package syncm
+
+import (
+	"fmt"
+	"testing"
+
+	uuidp "github.com/google/uuid"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlacement(t *testing.T) {
+	nodes := []postgres.Node{}
+	for i := int64(1); i <= 5; i++ {
+		nodes = append(nodes, postgres.Node{ID: i, Name: fmt.Sprintf("my-node-%v", i)})
+	}
+
+	countHolders := func(uuid string, replicationFactor int64, holders []int64) int64 {
+		n := int64(0)
+		for _, node := range nodes {
+			if ShouldHold(uuid, replicationFactor, holders, nodes, node.ID) {
+				n++
+			}
+		}
+		return n
+	}
+
+	t.Log("Test ShouldHold function")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tEvery node without file holds it if replication factor is 0", testID)
+		{
+			uuid := uuidp.NewString()
+			require.Equal(t, int64(4), countHolders(uuid, 0, []int64{1}), "Every node except holder must fetch file")
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tOnly missing copies are fetched", testID)
+		{
+			for i := 0; i < 100; i++ {
+				uuid := uuidp.NewString()
+				require.Equal(t, int64(2), countHolders(uuid, 3, []int64{1}), "Two nodes must fetch file")
+				require.Equal(t, int64(0), countHolders(uuid, 1, []int64{1}), "No one must fetch file")
+			}
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tPlacement is stable", testID)
+		{
+			uuid := uuidp.NewString()
+			first := PlacementOrder(uuid, nodes)
+			second := PlacementOrder(uuid, []postgres.Node{nodes[4], nodes[2], nodes[0], nodes[3], nodes[1]})
+			require.Equal(t, first, second, "Order must not depend on order of nodes")
+		}
+	}
+}
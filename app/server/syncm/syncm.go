@@ -1,25 +1,60 @@
 package syncm
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
-	"net/http"
+	"io"
+	"math/rand"
+	"os"
+	"slices"
+	"sync"
 	"time"
 
+	"github.com/alecthomas/kingpin/v2"
 	"github.com/muskelo/bronze-pheasant/app/server/log"
 	"github.com/muskelo/bronze-pheasant/app/server/pglock"
 	"github.com/muskelo/bronze-pheasant/app/server/postgres"
 	storagepkg "github.com/muskelo/bronze-pheasant/app/server/storage"
 	"github.com/muskelo/bronze-pheasant/lib/httpclient"
+	locklib "github.com/muskelo/bronze-pheasant/lib/lock"
 	"github.com/sirupsen/logrus"
 )
 
-func New(pg *postgres.Postgres, storage *storagepkg.Storage, nodeId int64) *SyncManager {
+const listenRetryInterval = 5 * time.Second
+
+// Download is aborted if node sends nothing for this time
+const downloadTimeout = 30 * time.Second
+
+// Max number of under-replicated files loaded by one query of scan
+const scanPageSize = int64(1000)
+
+var errDownloadStalled = errors.New("Node sent nothing for too long")
+
+func New(
+	pg *postgres.Postgres,
+	storage *storagepkg.Storage,
+	lock locklib.Lock,
+	nodeId int64,
+	workers int,
+	peerConcurrency int,
+	interval time.Duration,
+) *SyncManager {
 	return &SyncManager{
-		pg:      pg,
-		storage: storage,
-		nodeId:  nodeId,
-		log:     log.G("syncmanager"),
+		pg:              pg,
+		storage:         storage,
+		lock:            lock,
+		nodeId:          nodeId,
+		log:             log.G("syncmanager"),
+		workers:         workers,
+		peerConcurrency: peerConcurrency,
+		interval:        interval,
+		downloadTimeout: downloadTimeout,
+		queue:           make(chan job),
+		notifications:   make(chan string, 1024),
+		inflight:        map[int64]struct{}{},
+		peers:           map[int64]chan struct{}{},
 	}
 }
 
@@ -27,12 +62,96 @@ type SyncManager struct {
 	nodeId  int64
 	pg      *postgres.Postgres
 	storage *storagepkg.Storage
-	log     *logrus.Entry
+	// sync is paused while lock isn't fresh
+	lock locklib.Lock
+	log  *logrus.Entry
+
+	workers         int
+	peerConcurrency int
+	// interval between full scans
+	interval        time.Duration
+	downloadTimeout time.Duration
+	queue           chan job
+	notifications   chan string
+
+	mutex sync.Mutex
+	// files queued or being synced
+	inflight map[int64]struct{}
+	// semaphores limiting downloads from one node
+	peers map[int64]chan struct{}
+}
+
+// File queued for sync
+type job struct {
+	file postgres.File
+	// called by worker after local copy is registered, may be nil
+	synced func(ctx context.Context)
 }
 
+// Queues file for sync, waits for free worker. Returns false if file is already queued.
+func (sm *SyncManager) enqueue(ctx context.Context, j job) bool {
+	sm.mutex.Lock()
+	if _, ok := sm.inflight[j.file.ID]; ok {
+		sm.mutex.Unlock()
+		return false
+	}
+	sm.inflight[j.file.ID] = struct{}{}
+	sm.mutex.Unlock()
+
+	select {
+	case <-ctx.Done():
+		sm.done(j.file)
+		return false
+	case sm.queue <- j:
+		return true
+	}
+}
+
+// Queues file for sync like files found by sync manager itself, synced is called after local
+// copy is registered. Returns false if file is already queued.
+func (sm *SyncManager) Enqueue(ctx context.Context, file postgres.File, synced func(ctx context.Context)) bool {
+	return sm.enqueue(ctx, job{file: file, synced: synced})
+}
+
+func (sm *SyncManager) done(file postgres.File) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	delete(sm.inflight, file.ID)
+}
+
+func (sm *SyncManager) peerSlots(nodeID int64) chan struct{} {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	slots, ok := sm.peers[nodeID]
+	if !ok {
+		slots = make(chan struct{}, sm.peerConcurrency)
+		sm.peers[nodeID] = slots
+	}
+	return slots
+}
+
+// Orders nodes by number of active downloads from them, nodes with equal load are shuffled
+func (sm *SyncManager) orderSources(nodes []postgres.Node) []postgres.Node {
+	ordered := slices.Clone(nodes)
+	rand.Shuffle(len(ordered), func(i, j int) {
+		ordered[i], ordered[j] = ordered[j], ordered[i]
+	})
+	load := map[int64]int{}
+	for _, node := range ordered {
+		load[node.ID] = len(sm.peerSlots(node.ID))
+	}
+	slices.SortStableFunc(ordered, func(a, b postgres.Node) int {
+		return cmp.Compare(load[a.ID], load[b.ID])
+	})
+	return ordered
+}
+
+// Fetches file from one of active nodes and registers local copy
 func (sm *SyncManager) syncFile(ctx context.Context, file postgres.File) error {
 	// find nodes where file present
-	nodes, err := sm.pg.GetNodesWithinFileV2(ctx, file.UUID, 1, time.Now().Unix()-pglock.LifetimeSeconds)
+	nodes, err := sm.pg.GetNodesWithinFileV2(ctx, file.UUID, postgres.FileStateReady)
 	if err != nil {
 		return fmt.Errorf("Failed to get the list of nodes within file %v: %v\n. Skip...\n", file.UUID, err)
 	}
@@ -40,75 +159,334 @@ func (sm *SyncManager) syncFile(ctx context.Context, file postgres.File) error {
 		return fmt.Errorf("File %v doesn't present on any active node", file.UUID)
 	}
 
-	// try get file from another nodes
-	var resp *http.Response
-	for _, node := range nodes {
-		tmpResp, err := httpclient.GetV1InternalFiles(node.AdvertiseAddr, file.UUID)
-		if err != nil {
-			return fmt.Errorf("Failed get file %v from node %v: %v\n", file.UUID, node.Name, err)
-		} else {
-			resp = tmpResp
-			break
+	// try get file from another nodes, a corrupted copy is rejected and next node is tried
+	for _, node := range sm.orderSources(nodes) {
+		slots := sm.peerSlots(node.ID)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case slots <- struct{}{}:
 		}
+		err = sm.downloadFile(ctx, file, node)
+		<-slots
+		if err == nil {
+			err = sm.pg.AddFileToNode(ctx, sm.nodeId, file.ID)
+			if errors.Is(err, postgres.ErrFileNotExist) {
+				// file was aborted while it was downloaded
+				sm.storage.RemoveFile(file.UUID)
+			}
+			return err
+		}
+		sm.log.Errorf("Failed get file %v from node %v: %v", file.UUID, node.Name, err)
+	}
+	return fmt.Errorf("Failed to download file %v from any node. Skip...", file.UUID)
+}
+
+// Resets timer on every read which got data
+type idleReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
 	}
-	if resp == nil {
-		return fmt.Errorf("Failed to download file %v from any node. Skip...", file.UUID)
+	return n, err
+}
+
+// Downloads file from node and saves it localy, verifies checksum if it's known. Download is
+// aborted when ctx is done or node stalls.
+func (sm *SyncManager) downloadFile(ctx context.Context, file postgres.File, node postgres.Node) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	timer := time.AfterFunc(sm.downloadTimeout, func() { cancel(errDownloadStalled) })
+	defer timer.Stop()
+
+	resp, err := httpclient.GetV1InternalFiles(ctx, node.AdvertiseAddr, file.UUID)
+	if err != nil {
+		return cmp.Or(context.Cause(ctx), err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("Unexpected status code %v", resp.StatusCode)
+	}
 
-	// save file localy
-	_, err = sm.storage.WriteFile(file.UUID, resp.Body)
+	body := &idleReader{r: resp.Body, timer: timer, timeout: sm.downloadTimeout}
+	written, err := sm.storage.WriteFileWithChecksum(file.UUID, body, file.SHA256)
 	if err != nil {
-		return fmt.Errorf("Failed to write file %v on disk: %v. Skip...\n", file.UUID, err)
+		return cmp.Or(context.Cause(ctx), err)
+	}
+	if written != file.Size {
+		sm.storage.RemoveFile(file.UUID)
+		return fmt.Errorf("Size mismatch (%v != %v)", written, file.Size)
+	}
+	return nil
+}
+
+func (sm *SyncManager) removeLocalFile(file postgres.File) error {
+	err := sm.storage.RemoveFile(file.UUID)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Failed to remove file %v from disk: %v", file.UUID, err)
 	}
-	err = sm.pg.AddFileToNode(ctx, sm.nodeId, file.ID)
+	return nil
+}
+
+func (sm *SyncManager) removeFile(ctx context.Context, file postgres.File) error {
+	err := sm.removeLocalFile(file)
 	if err != nil {
 		return err
 	}
-	return nil
+	return sm.pg.RemoveFileFromNode(ctx, sm.nodeId, file.ID)
+}
+
+// Removes copy which was moved to another node
+func (sm *SyncManager) finishFileRemoval(ctx context.Context, file postgres.File) error {
+	err := sm.removeLocalFile(file)
+	if err != nil {
+		return err
+	}
+	return sm.pg.FinishFileRemoval(ctx, sm.nodeId, file.ID)
 }
 
 func (sm *SyncManager) run(ctx context.Context) error {
-	files, err := sm.pg.GetNotSyncedFiles(ctx, sm.nodeId)
+	deletedFiles, err := sm.pg.GetDeletedFilesOnNode(ctx, sm.nodeId)
 	if err != nil {
 		return err
 	}
-	if len(files) == 0 {
-		sm.log.Info("Not files to sync")
-		return nil
+	for _, file := range deletedFiles {
+		err = sm.removeFile(ctx, file)
+		if err != nil {
+			sm.log.Errorf("Remove error: %v", err.Error())
+		} else {
+			sm.log.Printf("Removed %v", file.UUID)
+		}
 	}
 
-	for _, file := range files {
-		err = sm.syncFile(ctx, file)
+	removals, err := sm.pg.GetFileRemovals(ctx, sm.nodeId)
+	if err != nil {
+		return err
+	}
+	for _, file := range removals {
+		err = sm.finishFileRemoval(ctx, file)
 		if err != nil {
-			sm.log.Errorf("Sync error: %v", err.Error())
+			sm.log.Errorf("Remove error: %v", err.Error())
 		} else {
-			sm.log.Printf("Synced %v", file.UUID)
+			sm.log.Printf("Removed moved %v", file.UUID)
 		}
 	}
+
+	// draining and decommissioned nodes don't take new copies
+	node, err := sm.pg.GetNodeByID(ctx, sm.nodeId)
+	if err != nil {
+		return err
+	}
+	if node.State != postgres.NodeStateActive {
+		syncBacklog.Set(0)
+		sm.log.Info("Node isn't active, skip sync")
+		return nil
+	}
+
+	replicationFactor, err := sm.pg.GetReplicationFactor(ctx)
+	if err != nil {
+		return err
+	}
+	activeNodes, err := sm.pg.GetActiveNodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	// files are paged by id, so scan after loss of node doesn't load every file at once
+	backlog, queued := 0, 0
+	after := int64(0)
+	for {
+		files, err := sm.pg.GetUnderReplicatedFiles(ctx, sm.nodeId, replicationFactor, after, scanPageSize)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			after = file.ID
+			if !sm.shouldHold(file, replicationFactor, activeNodes) {
+				continue
+			}
+			backlog++
+			if sm.enqueue(ctx, job{file: file.File}) {
+				queued++
+			}
+		}
+		if int64(len(files)) < scanPageSize {
+			break
+		}
+	}
+	syncBacklog.Set(float64(backlog))
+	if backlog == 0 {
+		sm.log.Info("Not files to sync")
+	} else {
+		sm.log.Infof("Queued %v files to sync, %v already in progress", queued, backlog-queued)
+	}
 	return nil
 }
 
+func (sm *SyncManager) shouldHold(file postgres.ReplicatedFile, replicationFactor int64, activeNodes []postgres.Node) bool {
+	if file.ReplicationFactor != 0 {
+		replicationFactor = file.ReplicationFactor
+	}
+	return ShouldHold(file.UUID, replicationFactor, file.Holders, activeNodes, sm.nodeId)
+}
+
+// Queues file which became ready if node must hold it
+func (sm *SyncManager) syncNotified(ctx context.Context, uuid string) error {
+	if !sm.lock.IsFresh() {
+		return nil
+	}
+	node, err := sm.pg.GetNodeByID(ctx, sm.nodeId)
+	if err != nil {
+		return err
+	}
+	if node.State != postgres.NodeStateActive {
+		return nil
+	}
+
+	replicationFactor, err := sm.pg.GetReplicationFactor(ctx)
+	if err != nil {
+		return err
+	}
+	file, err := sm.pg.GetUnderReplicatedFile(ctx, sm.nodeId, replicationFactor, uuid)
+	if err != nil {
+		return err
+	}
+	if !file.IsExist() {
+		return nil
+	}
+	activeNodes, err := sm.pg.GetActiveNodes(ctx)
+	if err != nil {
+		return err
+	}
+	if sm.shouldHold(file, replicationFactor, activeNodes) && sm.enqueue(ctx, job{file: file.File}) {
+		sm.log.Infof("Queued notified %v", uuid)
+	}
+	return nil
+}
+
+// Listens notifications about ready files, reconnects on errors
+func (sm *SyncManager) listen(ctx context.Context) {
+	for {
+		err := sm.pg.Listen(ctx, postgres.FileReadyChannel, sm.notifications)
+		if ctx.Err() != nil {
+			return
+		}
+		sm.log.Errorf("Listen error: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(listenRetryInterval):
+		}
+	}
+}
+
+func (sm *SyncManager) handleNotifications(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case uuid := <-sm.notifications:
+			err := sm.syncNotified(ctx, uuid)
+			if err != nil {
+				sm.log.Errorf("Sync error: %v", err.Error())
+			}
+		}
+	}
+}
+
+func (sm *SyncManager) worker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-sm.queue:
+			// file is found again by full scan after lock is back
+			if !sm.lock.IsFresh() {
+				sm.done(j.file)
+				continue
+			}
+			start := time.Now()
+			err := sm.syncFile(ctx, j.file)
+			syncDuration.Observe(time.Since(start).Seconds())
+			if err != nil {
+				syncFailures.Inc()
+				sm.log.Errorf("Sync error: %v", err.Error())
+			} else {
+				sm.log.Printf("Synced %v", j.file.UUID)
+				if j.synced != nil {
+					j.synced(ctx)
+				}
+			}
+			sm.done(j.file)
+		}
+	}
+}
+
 func (sm *SyncManager) Run(ctx context.Context) error {
+	// goroutines are stopped before returning
+	ctx, cancel := context.WithCancel(ctx)
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+	defer cancel()
+	for i := 0; i < sm.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sm.worker(ctx)
+		}()
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		sm.listen(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		sm.handleNotifications(ctx)
+	}()
+
 	for {
-		err := sm.run(ctx)
-		if err != nil {
-			return err
+		if sm.lock.IsFresh() {
+			err := sm.run(ctx)
+			if err != nil {
+				sm.log.Errorf("Sync error: %v", err)
+			}
+		} else {
+			sm.log.Warn("Node lock isn't fresh, sync paused")
 		}
 		select {
 		case <-ctx.Done():
 			return nil
-		case <-time.After(30 * time.Second):
+		case <-time.After(sm.interval):
 		}
 	}
 }
 
 // Default
 
+var (
+	syncmWorkers         = kingpin.Flag("syncm.workers", "Number of files synced in parallel").Default("4").Int()
+	syncmPeerConcurrency = kingpin.Flag("syncm.peer-concurrency", "Max parallel downloads from one node").Default("2").Int()
+	syncmInterval        = kingpin.Flag("syncm.interval", "Interval between full scans for not synced files").Default("30s").Duration()
+)
+
 var (
 	Default *SyncManager
 )
 
-func Init(nodeID int64) {
-	Default = New(postgres.Default, storagepkg.Default, nodeID)
+func Init(nodeID int64) error {
+	if *syncmWorkers < 1 {
+		return fmt.Errorf("syncm.workers must be at least 1, got %v", *syncmWorkers)
+	}
+	if *syncmPeerConcurrency < 1 {
+		return fmt.Errorf("syncm.peer-concurrency must be at least 1, got %v", *syncmPeerConcurrency)
+	}
+	Default = New(postgres.Default, storagepkg.Default, pglock.Default, nodeID, *syncmWorkers, *syncmPeerConcurrency, *syncmInterval)
+	return nil
 }
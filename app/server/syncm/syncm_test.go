@@ -0,0 +1,84 @@
+package syncm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	uuidp "github.com/google/uuid"
+	"github.com/muskelo/bronze-pheasant/app/server/postgres"
+	storagepkg "github.com/muskelo/bronze-pheasant/app/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadFile(t *testing.T) {
+	storage, err := storagepkg.New(t.TempDir())
+	require.NoError(t, err, "Must init new storage")
+	sm := New(nil, storage, nil, 1, 1, 1, time.Hour)
+	sm.downloadTimeout = 100 * time.Millisecond
+
+	// node sends beginning of file and stalls if stall is set
+	stall := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.Write([]byte("hel"))
+		if stall {
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte("lo"))
+	}))
+	t.Cleanup(server.Close)
+	node := postgres.Node{ID: 2, Name: "node2", AdvertiseAddr: server.URL}
+
+	checksum := sha256.Sum256([]byte("hello"))
+	newFile := func() postgres.File {
+		return postgres.File{UUID: uuidp.NewString(), Size: 5, SHA256: hex.EncodeToString(checksum[:])}
+	}
+
+	t.Log("Test downloadFile function")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tFile is downloaded", testID)
+		{
+			stall = false
+			require.NoError(t, sm.downloadFile(context.Background(), newFile(), node), "Must download file")
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tDownload from stalled node is aborted", testID)
+		{
+			stall = true
+			done := make(chan error, 1)
+			go func() { done <- sm.downloadFile(context.Background(), newFile(), node) }()
+			select {
+			case err := <-done:
+				require.ErrorIs(t, err, errDownloadStalled, "Must abort stalled download")
+			case <-time.After(5 * time.Second):
+				t.Fatal("Download from stalled node must be aborted")
+			}
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tDownload is aborted when context is canceled", testID)
+		{
+			stall = true
+			sm.downloadTimeout = time.Hour
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- sm.downloadFile(ctx, newFile(), node) }()
+			cancel()
+			select {
+			case err := <-done:
+				require.ErrorIs(t, err, context.Canceled, "Must abort canceled download")
+			case <-time.After(5 * time.Second):
+				t.Fatal("Canceled download must be aborted")
+			}
+		}
+	}
+}
@@ -1,12 +1,177 @@
 package httpclient
 
+import "context"
+import "crypto/hmac"
+import "crypto/rand"
+import "crypto/sha256"
+import "crypto/tls"
+import "encoding/hex"
+import "fmt"
+import "hash"
+import "io"
 import "net/http"
 import "net/url"
+import "strconv"
+import "time"
 
-func GetV1InternalFiles(baseUrl string, uuid string) (*http.Response, error) {
+// Max time to wait for response headers after request is sent, body of response isn't limited
+// because files can be big, callers abort stalled bodies with context
+const ResponseHeaderTimeout = 30 * time.Second
+
+// Client used for requests to internal api of other nodes
+var Client = &http.Client{Transport: newTransport()}
+
+// Headers of requests signed by shared secret of cluster
+const (
+	TimestampHeader = "X-Internal-Timestamp"
+	NonceHeader     = "X-Internal-Nonce"
+	SignatureHeader = "X-Internal-Signature"
+	// trailers of request with body, body is streamed so it's signed after it's sent
+	ContentSHA256Trailer    = "X-Internal-Content-Sha256"
+	ContentSignatureTrailer = "X-Internal-Content-Signature"
+)
+
+// Signed body modes, body of request is either empty or signed by trailers
+const (
+	BodyEmpty   = "empty"
+	BodyTrailer = "trailer"
+)
+
+// Shared secret of cluster, requests aren't signed if it's empty
+var secret string
+
+// Makes Client sign requests with shared secret of cluster
+func SetSecret(s string) {
+	secret = s
+}
+
+// Returns hex encoded hmac of request, uri includes query. Body is signed separately by BodySignature.
+func Signature(secret string, method string, host string, uri string, timestamp int64, nonce string, bodyMode string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%v\n%v\n%v\n%v\n%v\n%v", method, host, uri, timestamp, nonce, bodyMode)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Returns hex encoded hmac of body with hex encoded sha256 checksum, it's bound to request by its signature
+func BodySignature(secret string, signature string, checksum string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%v\n%v", signature, checksum)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Hashes body while it's sent and sets trailers when it's read to the end
+type signingBody struct {
+	body     io.ReadCloser
+	hash     hash.Hash
+	done     bool
+	finished func(checksum string)
+}
+
+func (b *signingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.hash.Write(p[:n])
+	if err == io.EOF && !b.done {
+		b.done = true
+		b.finished(hex.EncodeToString(b.hash.Sum(nil)))
+	}
+	return n, err
+}
+
+func (b *signingBody) Close() error {
+	return b.body.Close()
+}
+
+func do(req *http.Request) (*http.Response, error) {
+	if secret != "" {
+		timestamp := time.Now().Unix()
+		nonce := make([]byte, 16)
+		_, err := rand.Read(nonce)
+		if err != nil {
+			return nil, err
+		}
+		host := req.Host
+		if host == "" {
+			host = req.URL.Host
+		}
+		bodyMode := BodyEmpty
+		if req.Body != nil && req.Body != http.NoBody {
+			bodyMode = BodyTrailer
+		}
+		signature := Signature(secret, req.Method, host, req.URL.RequestURI(), timestamp, hex.EncodeToString(nonce), bodyMode)
+		req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(NonceHeader, hex.EncodeToString(nonce))
+		req.Header.Set(SignatureHeader, signature)
+		if bodyMode == BodyTrailer {
+			// trailers are sent only with chunked body
+			req.ContentLength = -1
+			req.GetBody = nil
+			req.Trailer = http.Header{ContentSHA256Trailer: nil, ContentSignatureTrailer: nil}
+			req.Body = &signingBody{body: req.Body, hash: sha256.New(), finished: func(checksum string) {
+				req.Trailer.Set(ContentSHA256Trailer, checksum)
+				req.Trailer.Set(ContentSignatureTrailer, BodySignature(secret, signature, checksum))
+			}}
+		}
+	}
+	return Client.Do(req)
+}
+
+// Makes Client present node certificate and verify other nodes with config
+func SetTLSConfig(config *tls.Config) {
+	transport := newTransport()
+	transport.TLSClientConfig = config
+	Client = &http.Client{Transport: transport}
+}
+
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = ResponseHeaderTimeout
+	return transport
+}
+
+// Requests file from node, request and reading of body are aborted when ctx is done
+func GetV1InternalFiles(ctx context.Context, baseUrl string, uuid string) (*http.Response, error) {
+	return GetV1InternalFilesWithHeader(ctx, baseUrl, uuid, nil)
+}
+
+// Same as GetV1InternalFiles but sends additional headers, e.g. Range
+func GetV1InternalFilesWithHeader(ctx context.Context, baseUrl string, uuid string, header http.Header) (*http.Response, error) {
+	uri, err := url.JoinPath(baseUrl, "/api/v1/internal/files", uuid)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	return do(req)
+}
+
+// Streams copy of file to node, request is aborted when ctx is done
+func PutV1InternalFiles(ctx context.Context, baseUrl string, uuid string, body io.Reader) (*http.Response, error) {
 	uri, err := url.JoinPath(baseUrl, "/api/v1/internal/files", uuid)
 	if err != nil {
 		return nil, err
 	}
-	return http.Get(uri)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, uri, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return do(req)
+}
+
+// Asks node to remove its copy of file which upload was aborted
+func DeleteV1InternalFiles(baseUrl string, uuid string) (*http.Response, error) {
+	uri, err := url.JoinPath(baseUrl, "/api/v1/internal/files", uuid)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodDelete, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	return do(req)
 }
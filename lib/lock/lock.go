@@ -6,4 +6,6 @@ var ErrLockExpired = errors.New("The Lock went bad")
 
 type Lock interface {
 	IsFresh() bool
+	// Returns id of node and epoch of lock, epoch grows every time lock is taken
+	Fence() (nodeID int64, epoch int64)
 }
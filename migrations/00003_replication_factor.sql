@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE public.file ADD replication_factor int8 DEFAULT 0 NOT NULL;
+CREATE TABLE public.setting (
+	"name" varchar NOT NULL,
+	value varchar NOT NULL,
+	CONSTRAINT setting_pk PRIMARY KEY (name)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE public.setting;
+ALTER TABLE public.file DROP COLUMN replication_factor;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE public.node ADD capacity int8 DEFAULT 0 NOT NULL;
+ALTER TABLE public.node ADD used int8 DEFAULT 0 NOT NULL;
+CREATE TABLE public.node_file_removal (
+	node_id int8 NOT NULL,
+	file_id int8 NOT NULL,
+	CONSTRAINT node_file_removal_pk PRIMARY KEY (node_id, file_id),
+	CONSTRAINT node_file_removal_file_fk FOREIGN KEY (file_id) REFERENCES public.file(id) ON DELETE CASCADE ON UPDATE CASCADE,
+	CONSTRAINT node_file_removal_node_fk FOREIGN KEY (node_id) REFERENCES public.node(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE public.node_file_removal;
+ALTER TABLE public.node DROP COLUMN used;
+ALTER TABLE public.node DROP COLUMN capacity;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE public.upload (
+	file_id int8 NOT NULL,
+	node_id int8 NOT NULL,
+	"offset" int8 DEFAULT 0 NOT NULL,
+	length int8 NOT NULL,
+	created_at int8 DEFAULT 0 NOT NULL,
+	CONSTRAINT upload_pk PRIMARY KEY (file_id),
+	CONSTRAINT upload_file_fk FOREIGN KEY (file_id) REFERENCES public.file(id) ON DELETE CASCADE ON UPDATE CASCADE,
+	CONSTRAINT upload_node_fk FOREIGN KEY (node_id) REFERENCES public.node(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE public.upload;
+-- +goose StatementEnd
@@ -0,0 +1,50 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE public.namespace (
+	id bigserial NOT NULL,
+	"name" text NOT NULL,
+	created_at int8 DEFAULT 0 NOT NULL,
+	CONSTRAINT namespace_pk PRIMARY KEY (id),
+	CONSTRAINT namespace_name_uq UNIQUE ("name")
+);
+ALTER TABLE public.file ADD namespace_id int8 NULL;
+-- keys are compared bytewise as in s3
+ALTER TABLE public.file ADD "key" text COLLATE "C" NULL;
+ALTER TABLE public.file ADD CONSTRAINT file_namespace_fk FOREIGN KEY (namespace_id) REFERENCES public.namespace(id) ON DELETE SET NULL ON UPDATE CASCADE;
+-- only one ready file can have the key, new and deleted versions don't conflict
+CREATE UNIQUE INDEX file_namespace_key_uq ON public.file (namespace_id, "key") WHERE state = 1;
+CREATE INDEX file_namespace_key_idx ON public.file (namespace_id, "key");
+
+CREATE TABLE public.multipart_upload (
+	id bigserial NOT NULL,
+	uuid text NOT NULL,
+	namespace_id int8 NOT NULL,
+	"key" text NOT NULL,
+	node_id int8 NOT NULL,
+	created_at int8 DEFAULT 0 NOT NULL,
+	CONSTRAINT multipart_upload_pk PRIMARY KEY (id),
+	CONSTRAINT multipart_upload_uuid_uq UNIQUE (uuid),
+	CONSTRAINT multipart_upload_namespace_fk FOREIGN KEY (namespace_id) REFERENCES public.namespace(id) ON DELETE CASCADE ON UPDATE CASCADE,
+	CONSTRAINT multipart_upload_node_fk FOREIGN KEY (node_id) REFERENCES public.node(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE TABLE public.multipart_part (
+	upload_id int8 NOT NULL,
+	"number" int8 NOT NULL,
+	size int8 NOT NULL,
+	sha256 text NOT NULL,
+	CONSTRAINT multipart_part_pk PRIMARY KEY (upload_id, "number"),
+	CONSTRAINT multipart_part_upload_fk FOREIGN KEY (upload_id) REFERENCES public.multipart_upload(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE public.multipart_part;
+DROP TABLE public.multipart_upload;
+DROP INDEX public.file_namespace_key_idx;
+DROP INDEX public.file_namespace_key_uq;
+ALTER TABLE public.file DROP CONSTRAINT file_namespace_fk;
+ALTER TABLE public.file DROP COLUMN "key";
+ALTER TABLE public.file DROP COLUMN namespace_id;
+DROP TABLE public.namespace;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE public.file ADD filename text DEFAULT '' NOT NULL;
+ALTER TABLE public.file ADD content_type text DEFAULT '' NOT NULL;
+ALTER TABLE public.file ADD tags jsonb DEFAULT '{}' NOT NULL;
+CREATE INDEX file_tags_idx ON public.file USING gin (tags jsonb_path_ops);
+ALTER TABLE public.multipart_upload ADD filename text DEFAULT '' NOT NULL;
+ALTER TABLE public.multipart_upload ADD content_type text DEFAULT '' NOT NULL;
+ALTER TABLE public.multipart_upload ADD tags jsonb DEFAULT '{}' NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE public.multipart_upload DROP COLUMN tags;
+ALTER TABLE public.multipart_upload DROP COLUMN content_type;
+ALTER TABLE public.multipart_upload DROP COLUMN filename;
+DROP INDEX public.file_tags_idx;
+ALTER TABLE public.file DROP COLUMN tags;
+ALTER TABLE public.file DROP COLUMN content_type;
+ALTER TABLE public.file DROP COLUMN filename;
+-- +goose StatementEnd
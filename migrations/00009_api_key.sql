@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE public.api_key (
+	id bigserial NOT NULL,
+	"name" text NOT NULL,
+	-- sha256 of key, key itself is shown only on creation
+	hash text NOT NULL,
+	scopes text[] DEFAULT '{}' NOT NULL,
+	-- key has access only to objects of namespace if set
+	namespace_id int8 NULL,
+	created_at int8 DEFAULT 0 NOT NULL,
+	revoked_at int8 DEFAULT 0 NOT NULL,
+	CONSTRAINT api_key_pk PRIMARY KEY (id),
+	CONSTRAINT api_key_hash_uq UNIQUE (hash),
+	CONSTRAINT api_key_namespace_fk FOREIGN KEY (namespace_id) REFERENCES public.namespace(id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE public.api_key;
+-- +goose StatementEnd
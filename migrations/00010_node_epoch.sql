@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- fencing token, increased every time lock of node is taken
+ALTER TABLE public.node ADD epoch int8 DEFAULT 0 NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE public.node DROP COLUMN epoch;
+-- +goose StatementEnd
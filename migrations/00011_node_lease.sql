@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- lease of node lock is computed with time of postgres, node is alive until it expires
+ALTER TABLE public.node ADD lease_expires_at timestamptz DEFAULT 'epoch' NOT NULL;
+UPDATE public.node SET lease_expires_at=to_timestamp(lock + 60) WHERE lock > 0;
+ALTER TABLE public.node DROP COLUMN lock;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE public.node ADD lock int8 DEFAULT 0 NOT NULL;
+UPDATE public.node SET lock=extract(epoch FROM lease_expires_at)::int8 - 60 WHERE lease_expires_at > 'epoch';
+ALTER TABLE public.node DROP COLUMN lease_expires_at;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- unfinished uploads are removed with their data after expiration
+ALTER TABLE public.upload ADD expires_at int8 DEFAULT 0 NOT NULL;
+UPDATE public.upload SET expires_at=created_at + 86400;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE public.upload DROP COLUMN expires_at;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- unfinished multipart uploads are removed with their parts after expiration
+ALTER TABLE public.multipart_upload ADD expires_at int8 DEFAULT 0 NOT NULL;
+UPDATE public.multipart_upload SET expires_at=created_at + 604800;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE public.multipart_upload DROP COLUMN expires_at;
+-- +goose StatementEnd